@@ -169,8 +169,7 @@ ExecStartPre=-/usr/bin/docker pull ${var.controller_image}
 ExecStart=/usr/bin/docker run --rm \
   --env-file /etc/etcd/config \
   -v /etc/etcd/:/etc/etcd/ \
-  ${var.controller_image} \
-  /bin/etcd-config
+  ${var.controller_image}
 RemainAfterExit=true
 
 [Install]
@@ -194,9 +193,17 @@ After=etcd-server-cert.service
 ExecStartPre=-/usr/bin/docker pull ${var.controller_image}
 ExecStart=/usr/bin/docker run --rm \
   --env-file /etc/etcd/config \
+  -e ETCD_TARGET_GROUP_ARN=${aws_lb_target_group.etcd.arn} \
+  -v /etc/etcd/:/etc/etcd/ \
+  ${var.controller_image} \
+  -watch
+ExecStop=-/usr/bin/docker run --rm \
+  --env-file /etc/etcd/config \
+  -e ETCD_TARGET_GROUP_ARN=${aws_lb_target_group.etcd.arn} \
   -v /etc/etcd/:/etc/etcd/ \
   ${var.controller_image} \
-  /bin/etcd-watcherd
+  leave
+TimeoutStopSec=120
 Restart=on-failure
 RestartSec=30
 
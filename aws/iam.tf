@@ -49,6 +49,21 @@ resource "aws_iam_role_policy" "etcd" {
       "Action": "ec2:Describe*",
       "Resource": "*",
       "Effect": "Allow"
+    },
+    {
+      "Sid": "TargetGroupDescribe",
+      "Action": "elasticloadbalancing:DescribeTargetHealth",
+      "Resource": "*",
+      "Effect": "Allow"
+    },
+    {
+      "Sid": "TargetGroupRegister",
+      "Action": [
+        "elasticloadbalancing:RegisterTargets",
+        "elasticloadbalancing:DeregisterTargets"
+      ],
+      "Resource": "${aws_lb_target_group.etcd.arn}",
+      "Effect": "Allow"
     }
   ]
 }
@@ -19,11 +19,14 @@ resource "aws_lb_listener" "etcd" {
   }
 }
 
+# Targets are registered by the controller once their member serves requests,
+# rather than by the autoscaling group at launch.
 resource "aws_lb_target_group" "etcd" {
-  name     = "${var.namespace}-etcd"
-  port     = 2379
-  protocol = "TCP"
-  vpc_id   = "${var.vpc_id}"
+  name                 = "${var.namespace}-etcd"
+  port                 = 2379
+  protocol             = "TCP"
+  vpc_id               = "${var.vpc_id}"
+  deregistration_delay = 30
 
   health_check {
     port     = 2379
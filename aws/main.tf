@@ -24,6 +24,16 @@ variable "instance_type" {
   default = "t2.small"
 }
 
+# Containers on the docker bridge network are one hop further away from the
+# metadata service, so IMDSv2 token responses need a hop limit of at least 2.
+variable "metadata_http_tokens" {
+  default = "required"
+}
+
+variable "metadata_hop_limit" {
+  default = 2
+}
+
 variable "vpc_id" {}
 
 variable "subnet_ids" {
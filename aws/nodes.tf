@@ -38,6 +38,12 @@ resource "aws_launch_configuration" "etcd" {
     volume_size = "${var.root_volume_size}"
   }
 
+  metadata_options {
+    http_endpoint               = "enabled"
+    http_tokens                 = "${var.metadata_http_tokens}"
+    http_put_response_hop_limit = "${var.metadata_hop_limit}"
+  }
+
   lifecycle {
     ignore_changes        = ["name"]
     create_before_destroy = true
@@ -57,8 +63,6 @@ resource "aws_autoscaling_group" "etcd" {
   health_check_grace_period = 30
   health_check_type         = "EC2"
 
-  target_group_arns = ["${aws_lb_target_group.etcd.arn}"]
-
   tag {
     key                 = "Name"
     value               = "${var.namespace}-etcd"
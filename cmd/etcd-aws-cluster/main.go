@@ -2,46 +2,223 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/coldog/etcd-aws-cluster/pkg/aws"
 	"github.com/coldog/etcd-aws-cluster/pkg/controller"
+	"github.com/coldog/etcd-aws-cluster/pkg/discovery"
 	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"github.com/coldog/etcd-aws-cluster/pkg/settings"
 )
 
+// flagSettings are the settings that flags override.
+var flagSettings = map[string]string{
+	"interval": "ETCD_WATCH_INTERVAL",
+	"watch":    "ETCD_WATCH",
+}
+
+// sensitiveSettings are masked in the config dump.
+var sensitiveSettings = map[string]bool{
+	"ETCD_PASSWORD": true,
+}
+
 func main() {
 	var (
-		interval = "5m"
-		watch    = false
+		interval   = "5m"
+		watch      = false
+		force      = false
+		configFile = os.Getenv("ETCD_AWS_CLUSTER_CONFIG")
 	)
 	flag.StringVar(&interval, "interval", interval, "Watch interval")
 	flag.BoolVar(&watch, "watch", watch, "Watch will poll the autoscaling group and continuously write to the configured file")
+	flag.BoolVar(&force, "force", force, "Leave the cluster without checking the lifecycle state of the instance")
+	flag.StringVar(&configFile, "config", configFile, "YAML config file, flags and env vars take precedence over it")
 	flag.Parse()
 
-	etcdClient, err := etcd.NewClient(etcd.GetEnvConfig())
+	command := flag.Arg(0)
+	switch command {
+	case "", "leave", "config":
+	default:
+		log.Fatalf("unknown command: %s", command)
+	}
+	leave := command == "leave"
+
+	if configFile != "" {
+		err := settings.LoadFile(configFile)
+		if err != nil {
+			log.Fatalf("failed to load config file: %v", err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if name, ok := flagSettings[f.Name]; ok {
+			settings.SetFlag(name, f.Value.String())
+		}
+	})
+	interval = settings.Get("ETCD_WATCH_INTERVAL", interval)
+	watch, err := strconv.ParseBool(settings.Get("ETCD_WATCH", strconv.FormatBool(watch)))
 	if err != nil {
-		log.Fatalf("failed to init etcd client: %v", err)
+		log.Fatalf("failed to parse watch: %v", err)
+	}
+
+	etcdConfig := etcd.GetEnvConfig()
+	discoveryConfig := discovery.GetEnvConfig()
+	awsConfig := aws.GetEnvConfig()
+	if unknown := settings.Unknown(); len(unknown) > 0 {
+		log.Fatalf("unknown settings in %s: %s", configFile, strings.Join(unknown, ", "))
+	}
+	if command == "config" {
+		dumpSettings(os.Stdout)
+		return
+	}
+
+	err = etcdConfig.Validate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	deadline, err := controller.StartupDeadline(etcdConfig)
+	if err != nil {
+		log.Fatalf("failed to parse startup timeout (%s): %v", etcdConfig.StartupTimeout, err)
+	}
+
+	var awsClient aws.Client
+	if needsAWS(discoveryConfig, etcdConfig) {
+		err = controller.WaitFor(deadline, "instance metadata", func() (cErr error) {
+			awsClient, cErr = aws.NewClient(awsConfig)
+			return cErr
+		})
+		if err != nil {
+			log.Fatalf("failed to init aws client: %v", err)
+		}
+		// A leaving instance is no longer InService.
+		if discoveryConfig.NeedsGroup() && !leave {
+			err = controller.WaitInService(awsClient, deadline)
+			if err != nil {
+				log.Fatalf("instance not in service: %v", err)
+			}
+		}
+	}
+
+	if etcdConfig.TuningDefaultsFile != "" {
+		defaults, tErr := etcd.InstanceTypeTuning(etcdConfig.TuningDefaultsFile, awsClient.InstanceType())
+		if tErr != nil {
+			log.Fatalf("failed to load tuning defaults: %v", tErr)
+		}
+		etcdConfig.Tuning = etcdConfig.Tuning.WithDefaults(defaults)
+	}
+	err = etcdConfig.Tuning.Validate()
+	if err != nil {
+		log.Fatalf("invalid tuning: %v", err)
 	}
 
-	awsClient, err := aws.NewClient()
+	disc, err := discovery.New(discoveryConfig, awsClient)
 	if err != nil {
-		log.Fatalf("failed to init aws client: %v", err)
+		log.Fatalf("failed to init discovery: %v", err)
 	}
 
-	ctrl := controller.NewController(awsClient, etcdClient)
+	if leave {
+		etcdClient, eErr := etcd.NewClient(etcdConfig)
+		if eErr != nil {
+			log.Fatalf("failed to init etcd client: %v", eErr)
+		}
+		ctrl := controller.NewController(disc, discoveryConfig.Policy(), awsClient, etcdClient)
+		if force {
+			err = ctrl.Leave("forced")
+		} else {
+			err = ctrl.LeaveIfDeparting()
+		}
+		if err != nil {
+			log.Fatalf("leave failed: %v", err)
+		}
+		return
+	}
+
+	err = controller.WaitCertificates(etcdConfig, deadline)
+	if err != nil {
+		log.Fatalf("certificates not ready: %v", err)
+	}
+
+	etcdClient, err := etcd.NewClient(etcdConfig)
+	if err != nil {
+		log.Fatalf("failed to init etcd client: %v", err)
+	}
+
+	ctrl := controller.NewController(disc, discoveryConfig.Policy(), awsClient, etcdClient)
 
 	if watch {
 		intervalTime, iErr := time.ParseDuration(interval)
 		if iErr != nil {
 			log.Fatalf("failed to parse interval (%s): %v", interval, iErr)
 		}
-		ctrl.Watch(intervalTime)
+		noticeInterval, iErr := controller.ParseInterval(etcdConfig.NoticeInterval)
+		if iErr != nil {
+			log.Fatalf("failed to parse notice interval (%s): %v", etcdConfig.NoticeInterval, iErr)
+		}
+		leaveBefore, iErr := controller.ParseInterval(etcdConfig.MaintenanceLeaveBefore)
+		if iErr != nil {
+			log.Fatalf("failed to parse maintenance leave window (%s): %v", etcdConfig.MaintenanceLeaveBefore, iErr)
+		}
+		ctrl.Watch(intervalTime, noticeInterval, leaveBefore)
 		return
 	}
 
-	err = ctrl.Run()
+	// Only the seeds form a new cluster, an instance that is not one yet
+	// waits to become one or for the cluster to come up and join it.
+	var runErr error
+	err = controller.WaitFor(deadline, "a cluster to join or seed", func() error {
+		runErr = ctrl.Run()
+		if runErr == controller.ErrNotSeed {
+			return runErr
+		}
+		return nil
+	})
+	if err == nil {
+		err = runErr
+	}
 	if err != nil {
 		log.Fatalf("run failed: %v", err)
 	}
 }
+
+// needsAWS reports whether the aws client is needed, which is only the case
+// for aws discovery, the aws backed certificate sources, Route53 records,
+// target groups, the instance type tuning defaults and the uploaded endpoints
+// manifest. This lets the controller run on-prem otherwise.
+func needsAWS(d discovery.Config, c etcd.Config) bool {
+	return d.NeedsAWS() || c.CABucket != "" || c.Route53ZoneID != "" ||
+		c.TargetGroupARN != "" || c.TuningDefaultsFile != "" || c.EndpointsBucket != "" ||
+		hasSecrets(c)
+}
+
+func hasSecrets(c etcd.Config) bool {
+	for _, ref := range []string{
+		c.ClientCASecret, c.ClientCertSecret, c.ClientKeySecret,
+		c.PeerCASecret, c.PeerCertSecret, c.PeerKeySecret,
+	} {
+		if ref != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// dumpSettings writes the effective settings with where each value came from.
+func dumpSettings(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tVALUE\tSOURCE")
+	for _, s := range settings.List() {
+		value := s.Value
+		if sensitiveSettings[s.Name] && value != "" {
+			value = "<redacted>"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Name, value, s.Source)
+	}
+	tw.Flush()
+}
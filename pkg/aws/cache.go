@@ -0,0 +1,74 @@
+package aws
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// cache holds api results for a short time, so lookups repeated within a
+// reconcile only hit the api once. Errors are not cached. A nil cache or a
+// zero ttl disables caching.
+type cache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newCache(ttl time.Duration) *cache {
+	return &cache{ttl: ttl, entries: map[string]cacheEntry{}}
+}
+
+func (c *cache) get(key string, load func() (interface{}, error)) (interface{}, error) {
+	if c == nil || c.ttl <= 0 {
+		return load()
+	}
+	c.lock.Lock()
+	entry, ok := c.entries[key]
+	c.lock.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.entries[key] = cacheEntry{value: value, expires: time.Now().Add(c.ttl)}
+	c.lock.Unlock()
+	return value, nil
+}
+
+// invalidate drops the entries with the given key prefix, used after writes
+// that change what a cached lookup returns.
+func (c *cache) invalidate(prefix string) {
+	if c == nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// instances caches an instance lookup. Callers get their own copy of the map.
+func (c *cache) instances(key string, load func() (map[string]Instance, error)) (map[string]Instance, error) {
+	value, err := c.get(key, func() (interface{}, error) { return load() })
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]Instance{}
+	for id, inst := range value.(map[string]Instance) {
+		out[id] = inst
+	}
+	return out, nil
+}
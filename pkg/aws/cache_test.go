@@ -0,0 +1,110 @@
+package aws
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache_Get(t *testing.T) {
+	c := newCache(time.Minute)
+	calls := 0
+	load := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	v, err := c.get("a", load)
+	require.Nil(t, err)
+	require.Equal(t, 1, v)
+	v, _ = c.get("a", load)
+	require.Equal(t, 1, v)
+	v, _ = c.get("b", load)
+	require.Equal(t, 2, v)
+
+	c.invalidate("a")
+	v, _ = c.get("a", load)
+	require.Equal(t, 3, v)
+	v, _ = c.get("b", load)
+	require.Equal(t, 2, v)
+}
+
+func TestCache_Disabled(t *testing.T) {
+	calls := 0
+	load := func() (interface{}, error) {
+		calls++
+		return calls, nil
+	}
+
+	var nilCache *cache
+	nilCache.get("a", load)
+	nilCache.get("a", load)
+	newCache(0).get("a", load)
+	require.Equal(t, 3, calls)
+}
+
+func TestCache_Errors(t *testing.T) {
+	c := newCache(time.Minute)
+	_, err := c.get("a", func() (interface{}, error) { return nil, errors.New("failed") })
+	require.NotNil(t, err)
+
+	v, err := c.get("a", func() (interface{}, error) { return "ok", nil })
+	require.Nil(t, err)
+	require.Equal(t, "ok", v)
+}
+
+func TestCache_Instances(t *testing.T) {
+	c := newCache(time.Minute)
+	load := func() (map[string]Instance, error) {
+		return map[string]Instance{"1": {IP: "10.0.0.1"}}, nil
+	}
+
+	out, err := c.instances("group", load)
+	require.Nil(t, err)
+	delete(out, "1")
+
+	// Callers can change their copy without changing the cached lookup.
+	out, _ = c.instances("group", load)
+	require.Equal(t, map[string]Instance{"1": {IP: "10.0.0.1"}}, out)
+}
+
+func TestClient_CachedLookups(t *testing.T) {
+	s := &SSMMock{}
+	e := &ELBMock{}
+	c := &client{ssm: s, elb: e, cache: newCache(time.Minute)}
+
+	s.On("GetParameter", &ssm.GetParameterInput{
+		Name:           aws.String("/etcd/token"),
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Value: aws.String("secret")},
+	}, nil).Once()
+	e.On("DescribeTargetHealth", &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String("arn"),
+	}).Return(&elbv2.DescribeTargetHealthOutput{}, nil).Twice()
+	e.On("RegisterTargets", &elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String("arn"),
+		Targets:        []*elbv2.TargetDescription{{Id: aws.String("i-1")}},
+	}).Return(&elbv2.RegisterTargetsOutput{}, nil).Once()
+
+	for i := 0; i < 2; i++ {
+		value, err := c.Secret("ssm:/etcd/token")
+		require.Nil(t, err)
+		require.Equal(t, "secret", string(value))
+	}
+
+	// Registering drops the cached target health.
+	g := c.TargetGroup("arn")
+	g.Targets()
+	g.Targets()
+	require.Nil(t, g.Register("i-1"))
+	g.Targets()
+
+	s.AssertExpectations(t)
+	e.AssertExpectations(t)
+}
@@ -2,21 +2,49 @@ package aws
 
 import (
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
+// secretsManagerPrefix is the parameter store path that resolves to secrets
+// manager secrets, which lets both sources be read through the ssm api.
+const secretsManagerPrefix = "/aws/reference/secretsmanager/"
+
 var createSession = session.NewSession
 
+// Instance is an instance discovered from an autoscaling group or by tag. The
+// lifecycle state and health status use the autoscaling values, instances
+// found through EC2 have their state mapped onto them.
+type Instance struct {
+	IP             string
+	PrivateDNS     string
+	LifecycleState string
+	HealthStatus   string
+}
+
 type Client interface {
 	Hostname() string
 	IP() string
@@ -24,17 +52,54 @@ type Client interface {
 	Region() string
 	GroupName() string
 
-	GroupInstances() (map[string]string, error)
+	// InstanceType is the EC2 instance type from the identity document.
+	InstanceType() string
+
+	// LifecycleState returns the current autoscaling lifecycle state of this
+	// instance, it is never cached.
+	LifecycleState() (string, error)
+
+	GroupInstances() (map[string]Instance, error)
+
+	// TaggedGroupInstances returns the union of the instances of every
+	// autoscaling group tagged with key=value.
+	TaggedGroupInstances(key, value string) (map[string]Instance, error)
+
+	// TaggedInstances returns the running instances tagged with key=value.
+	TaggedInstances(key, value string) (map[string]Instance, error)
 
 	Upload(filename, bucket, key string) error
+	Download(bucket, key string) ([]byte, error)
+
+	// Decrypt decrypts a KMS ciphertext blob.
+	Decrypt(ciphertext []byte) ([]byte, error)
+
+	// Secret fetches a secret value from a reference of the form
+	// "ssm:<parameter-name>" or "secretsmanager:<secret-id>".
+	Secret(ref string) ([]byte, error)
+
+	// Zone returns the Route53 hosted zone with the given ID.
+	Zone(id string) Zone
+
+	// TargetGroup returns the load balancer target group with the given ARN.
+	TargetGroup(arn string) TargetGroup
+
+	// Notices returns the pending spot interruption and scheduled maintenance
+	// events of this instance from the metadata service.
+	Notices() ([]Notice, error)
 }
 
-func NewClient() (Client, error) {
+// NewClient loads the identity of this instance from the metadata service
+// using IMDSv2 session tokens and creates the api clients for its region.
+func NewClient(cfg Config) (Client, error) {
 	sess, err := createSession()
 	if err != nil {
 		return nil, err
 	}
-	meta := ec2metadata.New(sess)
+	meta, err := newMetadata(sess, cfg)
+	if err != nil {
+		return nil, err
+	}
 	doc, err := meta.GetInstanceIdentityDocument()
 	if err != nil {
 		return nil, err
@@ -51,102 +116,284 @@ func NewClient() (Client, error) {
 	if err != nil {
 		return nil, err
 	}
-	sess, err = createSession(&aws.Config{
-		Region: &doc.Region,
-	})
+	retries, err := countOr(cfg.MaxRetries, 8)
 	if err != nil {
 		return nil, err
 	}
-	c := &client{
-		asg:        autoscaling.New(sess),
-		ec2:        ec2.New(sess),
-		s3:         s3.New(sess),
-		hostname:   hostname,
-		ip:         ip,
-		region:     doc.Region,
-		instanceID: instanceID,
+	base, err := durationOr(cfg.RetryBaseDelay, 100*time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	max, err := durationOr(cfg.RetryMaxDelay, 20*time.Second)
+	if err != nil {
+		return nil, err
 	}
-	err = c.loadGroupName()
+	ttl, err := durationOr(cfg.CacheTTL, 15*time.Second)
 	if err != nil {
 		return nil, err
 	}
+	sess, err = createSession(request.WithRetryer(&aws.Config{
+		Region:      &doc.Region,
+		Credentials: roleCredentials(sess, meta, doc.Region),
+	}, newRetryer(retries, base, max)))
+	if err != nil {
+		return nil, err
+	}
+	c := &client{
+		asg:          autoscaling.New(sess),
+		ec2:          ec2.New(sess),
+		elb:          elbv2.New(sess),
+		kms:          kms.New(sess),
+		r53:          route53.New(sess),
+		s3:           s3.New(sess),
+		ssm:          ssm.New(sess),
+		hostname:     hostname,
+		ip:           ip,
+		region:       doc.Region,
+		instanceType: doc.InstanceType,
+		instanceID:   instanceID,
+		meta:         meta,
+		cache:        newCache(ttl),
+	}
 	return c, nil
 }
 
 type client struct {
-	asg        autoscalingiface.AutoScalingAPI
-	ec2        ec2iface.EC2API
-	s3         s3iface.S3API
-	hostname   string
-	ip         string
-	region     string
-	instanceID string
-	groupName  string
-}
-
-func (c *client) Region() string     { return c.region }
-func (c *client) Hostname() string   { return c.hostname }
-func (c *client) IP() string         { return c.ip }
-func (c *client) InstanceID() string { return c.instanceID }
-func (c *client) GroupName() string  { return c.groupName }
-
-func (c *client) loadGroupName() error {
-	var name string
-	err := c.asg.DescribeAutoScalingGroupsPages(
-		&autoscaling.DescribeAutoScalingGroupsInput{},
-		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
-			for _, g := range page.AutoScalingGroups {
-				for _, inst := range g.Instances {
-					if c.instanceID == *inst.InstanceId {
-						name = *g.AutoScalingGroupName
-						return false
-					}
-				}
-			}
-			return true
-		},
-	)
+	asg          autoscalingiface.AutoScalingAPI
+	ec2          ec2iface.EC2API
+	elb          elbv2iface.ELBV2API
+	kms          kmsiface.KMSAPI
+	r53          route53iface.Route53API
+	s3           s3iface.S3API
+	ssm          ssmiface.SSMAPI
+	hostname     string
+	ip           string
+	region       string
+	instanceType string
+	instanceID   string
+	meta         *ec2metadata.EC2Metadata
+	cache        *cache
+
+	mu        sync.Mutex
+	groupName string
+}
+
+func (c *client) Region() string       { return c.region }
+func (c *client) InstanceType() string { return c.instanceType }
+func (c *client) Hostname() string     { return c.hostname }
+func (c *client) IP() string           { return c.ip }
+func (c *client) InstanceID() string   { return c.instanceID }
+
+// GroupName returns the autoscaling group of this instance, or an empty name
+// when it is not in one.
+func (c *client) GroupName() string {
+	name, _ := c.group()
+	return name
+}
+
+// group looks up the group of this instance on first use, directly rather
+// than paging through every group in the account. The lookup is deferred
+// since instances found by tag need not be in a group at all.
+func (c *client) group() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.groupName != "" {
+		return c.groupName, nil
+	}
+	inst, err := c.describeSelf()
 	if err != nil {
-		return err
+		return "", err
 	}
-	if name == "" {
-		return errors.New("aws: autoscaling group not found")
+	c.groupName = *inst.AutoScalingGroupName
+	return c.groupName, nil
+}
+
+func (c *client) LifecycleState() (string, error) {
+	inst, err := c.describeSelf()
+	if err != nil {
+		return "", err
 	}
-	c.groupName = name
-	return nil
+	return aws.StringValue(inst.LifecycleState), nil
 }
 
-func (c *client) GroupInstances() (map[string]string, error) {
-	groups, err := c.asg.DescribeAutoScalingGroups(&autoscaling.DescribeAutoScalingGroupsInput{
-		AutoScalingGroupNames: []*string{&c.groupName},
+func (c *client) describeSelf() (*autoscaling.InstanceDetails, error) {
+	out, err := c.asg.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String(c.instanceID)},
 	})
 	if err != nil {
 		return nil, err
 	}
-	instances := []*string{}
-	for _, group := range groups.AutoScalingGroups {
-		for _, inst := range group.Instances {
-			instances = append(instances, inst.InstanceId)
+	for _, inst := range out.AutoScalingInstances {
+		if aws.StringValue(inst.InstanceId) == c.instanceID && inst.AutoScalingGroupName != nil {
+			return inst, nil
 		}
 	}
-	ec2Inst, err := c.ec2.DescribeInstances(&ec2.DescribeInstancesInput{
-		InstanceIds: instances,
+	return nil, errors.New("aws: autoscaling group not found")
+}
+
+func (c *client) GroupInstances() (map[string]Instance, error) {
+	return c.cache.instances("group", func() (map[string]Instance, error) {
+		name, err := c.group()
+		if err != nil {
+			return nil, err
+		}
+		return c.groupInstances([]*string{&name})
+	})
+}
+
+func (c *client) TaggedGroupInstances(key, value string) (map[string]Instance, error) {
+	return c.cache.instances("tagged-group/"+key+"="+value, func() (map[string]Instance, error) {
+		return c.taggedGroupInstances(key, value)
 	})
+}
+
+func (c *client) taggedGroupInstances(key, value string) (map[string]Instance, error) {
+	names := []*string{}
+	err := c.asg.DescribeTagsPages(
+		&autoscaling.DescribeTagsInput{
+			Filters: []*autoscaling.Filter{
+				{Name: aws.String("key"), Values: []*string{&key}},
+				{Name: aws.String("value"), Values: []*string{&value}},
+			},
+		},
+		func(page *autoscaling.DescribeTagsOutput, lastPage bool) bool {
+			for _, tag := range page.Tags {
+				names = append(names, tag.ResourceId)
+			}
+			return true
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
-	out := map[string]string{}
-	for _, rev := range ec2Inst.Reservations {
-		for _, inst := range rev.Instances {
-			if len(inst.NetworkInterfaces) == 0 {
-				continue
+	if len(names) == 0 {
+		return nil, fmt.Errorf("aws: no autoscaling groups tagged %s=%s", key, value)
+	}
+	return c.groupInstances(names)
+}
+
+func (c *client) TaggedInstances(key, value string) (map[string]Instance, error) {
+	return c.cache.instances("tagged/"+key+"="+value, func() (map[string]Instance, error) {
+		return c.describeInstances(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("tag:" + key), Values: []*string{&value}},
+				{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running"})},
+			},
+		})
+	})
+}
+
+// Batch sizes for the names and ids passed to a single describe call.
+const (
+	groupBatchSize    = 50
+	instanceBatchSize = 100
+)
+
+// groupInstances returns the instances of all of the named groups. Any error
+// fails the whole lookup so that a partial result never looks like instances
+// have left the cluster.
+func (c *client) groupInstances(names []*string) (map[string]Instance, error) {
+	ids := []*string{}
+	states := map[string]*autoscaling.Instance{}
+	for i := 0; i < len(names); i += groupBatchSize {
+		err := c.asg.DescribeAutoScalingGroupsPages(
+			&autoscaling.DescribeAutoScalingGroupsInput{
+				AutoScalingGroupNames: names[i:minInt(i+groupBatchSize, len(names))],
+			},
+			func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+				for _, group := range page.AutoScalingGroups {
+					for _, inst := range group.Instances {
+						ids = append(ids, inst.InstanceId)
+						states[*inst.InstanceId] = inst
+					}
+				}
+				return true
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// An empty id list would describe every instance in the account.
+	out := map[string]Instance{}
+	if len(ids) == 0 {
+		return out, nil
+	}
+
+	for i := 0; i < len(ids); i += instanceBatchSize {
+		described, err := c.describeInstances(&ec2.DescribeInstancesInput{
+			InstanceIds: ids[i:minInt(i+instanceBatchSize, len(ids))],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for id, inst := range described {
+			if state, ok := states[id]; ok && state.LifecycleState != nil {
+				inst.LifecycleState = *state.LifecycleState
+				inst.HealthStatus = aws.StringValue(state.HealthStatus)
 			}
-			out[*inst.InstanceId] = *inst.NetworkInterfaces[0].PrivateIpAddress
+			out[id] = inst
 		}
 	}
 	return out, nil
 }
 
+// describeInstances returns the matching instances with their lifecycle
+// state derived from the EC2 instance state. Instances without a private IP
+// are returned with an empty IP rather than dropped.
+func (c *client) describeInstances(in *ec2.DescribeInstancesInput) (map[string]Instance, error) {
+	out := map[string]Instance{}
+	err := c.ec2.DescribeInstancesPages(in, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, rev := range page.Reservations {
+			for _, inst := range rev.Instances {
+				out[*inst.InstanceId] = Instance{
+					IP:             primaryIP(inst),
+					PrivateDNS:     aws.StringValue(inst.PrivateDnsName),
+					LifecycleState: lifecycleState(inst.State),
+					HealthStatus:   "Healthy",
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// primaryIP returns the private IP of the interface at device index 0, the
+// interface order in the response is not guaranteed.
+func primaryIP(inst *ec2.Instance) string {
+	for _, iface := range inst.NetworkInterfaces {
+		if iface.Attachment != nil && aws.Int64Value(iface.Attachment.DeviceIndex) == 0 {
+			return aws.StringValue(iface.PrivateIpAddress)
+		}
+	}
+	return aws.StringValue(inst.PrivateIpAddress)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func lifecycleState(state *ec2.InstanceState) string {
+	if state == nil {
+		return autoscaling.LifecycleStateInService
+	}
+	switch aws.StringValue(state.Name) {
+	case ec2.InstanceStateNamePending:
+		return autoscaling.LifecycleStatePending
+	case ec2.InstanceStateNameRunning:
+		return autoscaling.LifecycleStateInService
+	}
+	return autoscaling.LifecycleStateTerminating
+}
+
 func (c *client) Upload(filename, bucket, key string) (err error) {
 	f, err := os.Open(filename)
 	if err != nil {
@@ -164,3 +411,58 @@ func (c *client) Upload(filename, bucket, key string) (err error) {
 	})
 	return err
 }
+
+func (c *client) Download(bucket, key string) ([]byte, error) {
+	out, err := c.s3.GetObject(&s3.GetObjectInput{
+		Key:    &key,
+		Bucket: &bucket,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func (c *client) Decrypt(ciphertext []byte) ([]byte, error) {
+	out, err := c.kms.Decrypt(&kms.DecryptInput{
+		CiphertextBlob: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+func (c *client) Secret(ref string) ([]byte, error) {
+	value, err := c.cache.get("secret/"+ref, func() (interface{}, error) {
+		return c.secret(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]byte), nil
+}
+
+func (c *client) secret(ref string) ([]byte, error) {
+	var name string
+	switch {
+	case strings.HasPrefix(ref, "ssm:"):
+		name = strings.TrimPrefix(ref, "ssm:")
+	case strings.HasPrefix(ref, "secretsmanager:"):
+		name = secretsManagerPrefix + strings.TrimPrefix(ref, "secretsmanager:")
+	default:
+		return nil, fmt.Errorf("aws: invalid secret reference: %s", ref)
+	}
+	out, err := c.ssm.GetParameter(&ssm.GetParameterInput{
+		Name:           &name,
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Parameter == nil || out.Parameter.Value == nil {
+		return nil, fmt.Errorf("aws: secret has no value: %s", ref)
+	}
+	return []byte(*out.Parameter.Value), nil
+}
@@ -1,6 +1,9 @@
 package aws
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -8,6 +11,8 @@ import (
 
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -15,6 +20,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
@@ -37,10 +44,12 @@ type EC2Mock struct {
 	mock.Mock
 }
 
-func (m *EC2Mock) DescribeInstances(in *ec2.DescribeInstancesInput) (
-	*ec2.DescribeInstancesOutput, error) {
+func (m *EC2Mock) DescribeInstancesPages(
+	in *ec2.DescribeInstancesInput,
+	fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
 	a := m.Called(in)
-	return a.Get(0).(*ec2.DescribeInstancesOutput), a.Error(1)
+	fn(a.Get(0).(*ec2.DescribeInstancesOutput), true)
+	return a.Error(1)
 }
 
 type ASGMock struct {
@@ -48,13 +57,6 @@ type ASGMock struct {
 	mock.Mock
 }
 
-func (m *ASGMock) DescribeAutoScalingGroups(
-	in *autoscaling.DescribeAutoScalingGroupsInput) (
-	*autoscaling.DescribeAutoScalingGroupsOutput, error) {
-	a := m.Called(in)
-	return a.Get(0).(*autoscaling.DescribeAutoScalingGroupsOutput), a.Error(1)
-}
-
 func (m *ASGMock) DescribeAutoScalingGroupsPages(
 	in *autoscaling.DescribeAutoScalingGroupsInput,
 	fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
@@ -63,6 +65,19 @@ func (m *ASGMock) DescribeAutoScalingGroupsPages(
 	return a.Error(1)
 }
 
+func (m *ASGMock) DescribeAutoScalingInstances(in *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*autoscaling.DescribeAutoScalingInstancesOutput), a.Error(1)
+}
+
+func (m *ASGMock) DescribeTagsPages(
+	in *autoscaling.DescribeTagsInput,
+	fn func(*autoscaling.DescribeTagsOutput, bool) bool) error {
+	a := m.Called(in)
+	fn(a.Get(0).(*autoscaling.DescribeTagsOutput), true)
+	return a.Error(1)
+}
+
 type S3Mock struct {
 	s3iface.S3API
 	mock.Mock
@@ -73,9 +88,41 @@ func (m *S3Mock) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
 	return a.Get(0).(*s3.PutObjectOutput), a.Error(1)
 }
 
+func (m *S3Mock) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*s3.GetObjectOutput), a.Error(1)
+}
+
+type KMSMock struct {
+	kmsiface.KMSAPI
+	mock.Mock
+}
+
+func (m *KMSMock) Decrypt(in *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*kms.DecryptOutput), a.Error(1)
+}
+
+type SSMMock struct {
+	ssmiface.SSMAPI
+	mock.Mock
+}
+
+func (m *SSMMock) GetParameter(in *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*ssm.GetParameterOutput), a.Error(1)
+}
+
 func TestClient_Load(t *testing.T) {
 	createSession = func(...*aws.Config) (*session.Session, error) { return mockSession, nil }
-	_, err := NewClient()
+
+	// An instance outside of any autoscaling group, as found by the ec2-tag
+	// backend, still gets a client and only the group lookups fail.
+	c, err := NewClient(Config{})
+	require.Nil(t, err)
+	require.Equal(t, "", c.GroupName())
+
+	_, err = c.GroupInstances()
 	require.Equal(t, "aws: autoscaling group not found", err.Error())
 }
 
@@ -92,19 +139,27 @@ func TestClient_Instances(t *testing.T) {
 		groupName:  "test",
 	}
 
-	a.On("DescribeAutoScalingGroups", &autoscaling.DescribeAutoScalingGroupsInput{
+	a.On("DescribeAutoScalingGroupsPages", &autoscaling.DescribeAutoScalingGroupsInput{
 		AutoScalingGroupNames: []*string{aws.String("test")},
 	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
 		AutoScalingGroups: []*autoscaling.Group{
 			{
 				Instances: []*autoscaling.Instance{
-					{InstanceId: aws.String("1")},
-					{InstanceId: aws.String("2")},
+					{
+						InstanceId:     aws.String("1"),
+						LifecycleState: aws.String("InService"),
+						HealthStatus:   aws.String("Healthy"),
+					},
+					{
+						InstanceId:     aws.String("2"),
+						LifecycleState: aws.String("Standby"),
+						HealthStatus:   aws.String("Healthy"),
+					},
 				},
 			},
 		},
 	}, nil)
-	e.On("DescribeInstances", &ec2.DescribeInstancesInput{
+	e.On("DescribeInstancesPages", &ec2.DescribeInstancesInput{
 		InstanceIds: []*string{aws.String("1"), aws.String("2")},
 	}).Return(&ec2.DescribeInstancesOutput{
 		Reservations: []*ec2.Reservation{{
@@ -112,12 +167,14 @@ func TestClient_Instances(t *testing.T) {
 				{
 					InstanceId: aws.String("1"),
 					NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
 						PrivateIpAddress: aws.String("1.ec2.internal"),
 					}},
 				},
 				{
 					InstanceId: aws.String("2"),
 					NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
 						PrivateIpAddress: aws.String("2.ec2.internal"),
 					}},
 				},
@@ -127,43 +184,271 @@ func TestClient_Instances(t *testing.T) {
 
 	m, err := c.GroupInstances()
 	require.Nil(t, err)
-	require.Equal(t, map[string]string{
-		"1": "1.ec2.internal",
-		"2": "2.ec2.internal",
+	require.Equal(t, map[string]Instance{
+		"1": {IP: "1.ec2.internal", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"2": {IP: "2.ec2.internal", LifecycleState: "Standby", HealthStatus: "Healthy"},
 	}, m)
 
 	e.AssertExpectations(t)
 	a.AssertExpectations(t)
 }
 
-func TestClient_LoadName(t *testing.T) {
+func TestClient_TaggedGroupInstances(t *testing.T) {
+	a := &ASGMock{}
+	e := &EC2Mock{}
+
+	c := &client{
+		asg:        a,
+		ec2:        e,
+		hostname:   "1.ec2.internal",
+		region:     "us-west-2",
+		instanceID: "1",
+		groupName:  "test-a",
+	}
+
+	a.On("DescribeTagsPages", &autoscaling.DescribeTagsInput{
+		Filters: []*autoscaling.Filter{
+			{Name: aws.String("key"), Values: []*string{aws.String("etcd-cluster")}},
+			{Name: aws.String("value"), Values: []*string{aws.String("prod")}},
+		},
+	}).Return(&autoscaling.DescribeTagsOutput{
+		Tags: []*autoscaling.TagDescription{
+			{ResourceId: aws.String("test-a")},
+			{ResourceId: aws.String("test-b")},
+		},
+	}, nil)
+	a.On("DescribeAutoScalingGroupsPages", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String("test-a"), aws.String("test-b")},
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{
+			{Instances: []*autoscaling.Instance{{InstanceId: aws.String("1")}}},
+			{Instances: []*autoscaling.Instance{{InstanceId: aws.String("2")}}},
+		},
+	}, nil)
+	e.On("DescribeInstancesPages", &ec2.DescribeInstancesInput{
+		InstanceIds: []*string{aws.String("1"), aws.String("2")},
+	}).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{
+				{
+					InstanceId: aws.String("1"),
+					NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.0.1"),
+					}},
+				},
+				{
+					InstanceId: aws.String("2"),
+					NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.1.1"),
+					}},
+				},
+			},
+		}},
+	}, nil)
+
+	m, err := c.TaggedGroupInstances("etcd-cluster", "prod")
+	require.Nil(t, err)
+	require.Equal(t, map[string]Instance{
+		"1": {IP: "10.0.0.1", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"2": {IP: "10.0.1.1", LifecycleState: "InService", HealthStatus: "Healthy"},
+	}, m)
+
+	e.AssertExpectations(t)
+	a.AssertExpectations(t)
+}
+
+func TestClient_TaggedInstances(t *testing.T) {
+	e := &EC2Mock{}
+
+	c := &client{
+		ec2:        e,
+		hostname:   "1.ec2.internal",
+		region:     "us-west-2",
+		instanceID: "1",
+		groupName:  "test",
+	}
+
+	e.On("DescribeInstancesPages", &ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("tag:etcd-cluster"), Values: []*string{aws.String("prod")}},
+			{Name: aws.String("instance-state-name"), Values: aws.StringSlice([]string{"pending", "running"})},
+		},
+	}).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{
+				{
+					InstanceId: aws.String("1"),
+					State:      &ec2.InstanceState{Name: aws.String("running")},
+					NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.0.1"),
+					}},
+				},
+				{
+					InstanceId: aws.String("2"),
+					State:      &ec2.InstanceState{Name: aws.String("pending")},
+					NetworkInterfaces: []*ec2.InstanceNetworkInterface{{
+						Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+						PrivateIpAddress: aws.String("10.0.1.1"),
+					}},
+				},
+			},
+		}},
+	}, nil)
+
+	m, err := c.TaggedInstances("etcd-cluster", "prod")
+	require.Nil(t, err)
+	require.Equal(t, map[string]Instance{
+		"1": {IP: "10.0.0.1", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"2": {IP: "10.0.1.1", LifecycleState: "Pending", HealthStatus: "Healthy"},
+	}, m)
+
+	e.AssertExpectations(t)
+}
+
+func TestClient_EmptyGroup(t *testing.T) {
 	a := &ASGMock{}
+	e := &EC2Mock{}
 
 	c := &client{
 		asg:        a,
+		ec2:        e,
 		hostname:   "1.ec2.internal",
 		region:     "us-west-2",
 		instanceID: "1",
 		groupName:  "test",
 	}
 
-	a.On("DescribeAutoScalingGroupsPages",
-		&autoscaling.DescribeAutoScalingGroupsInput{}).
-		Return(&autoscaling.DescribeAutoScalingGroupsOutput{
-			AutoScalingGroups: []*autoscaling.Group{
+	a.On("DescribeAutoScalingGroupsPages", &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String("test")},
+	}).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{}},
+	}, nil)
+
+	m, err := c.GroupInstances()
+	require.Nil(t, err)
+	require.Empty(t, m)
+
+	a.AssertExpectations(t)
+	e.AssertNotCalled(t, "DescribeInstancesPages", mock.Anything)
+}
+
+func TestClient_InstanceAddresses(t *testing.T) {
+	e := &EC2Mock{}
+
+	c := &client{ec2: e}
+
+	e.On("DescribeInstancesPages", mock.Anything).Return(&ec2.DescribeInstancesOutput{
+		Reservations: []*ec2.Reservation{{
+			Instances: []*ec2.Instance{
 				{
-					AutoScalingGroupName: aws.String("new"),
-					Instances: []*autoscaling.Instance{
-						{InstanceId: aws.String("1")},
-						{InstanceId: aws.String("2")},
+					InstanceId: aws.String("1"),
+					NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+						{
+							Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(1)},
+							PrivateIpAddress: aws.String("10.0.1.1"),
+						},
+						{
+							Attachment:       &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+							PrivateIpAddress: aws.String("10.0.0.1"),
+						},
 					},
 				},
+				{
+					InstanceId:       aws.String("2"),
+					PrivateIpAddress: aws.String("10.0.0.2"),
+					PrivateDnsName:   aws.String("ip-10-0-0-2.ec2.internal"),
+				},
+				{
+					InstanceId: aws.String("3"),
+				},
 			},
-		}, nil)
+		}},
+	}, nil)
 
-	err := c.loadGroupName()
+	m, err := c.describeInstances(&ec2.DescribeInstancesInput{})
 	require.Nil(t, err)
-	require.Equal(t, "new", c.groupName)
+	require.Equal(t, "10.0.0.1", m["1"].IP)
+	require.Equal(t, "10.0.0.2", m["2"].IP)
+	require.Equal(t, "ip-10-0-0-2.ec2.internal", m["2"].PrivateDNS)
+	require.Contains(t, m, "3")
+	require.Equal(t, "", m["3"].IP)
+}
+
+func TestClient_InstanceBatches(t *testing.T) {
+	a := &ASGMock{}
+	e := &EC2Mock{}
+
+	c := &client{
+		asg:       a,
+		ec2:       e,
+		groupName: "test",
+	}
+
+	instances := []*autoscaling.Instance{}
+	for i := 0; i < 150; i++ {
+		instances = append(instances, &autoscaling.Instance{InstanceId: aws.String(fmt.Sprint(i))})
+	}
+	a.On("DescribeAutoScalingGroupsPages", mock.Anything).Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+		AutoScalingGroups: []*autoscaling.Group{{Instances: instances}},
+	}, nil)
+	e.On("DescribeInstancesPages", mock.MatchedBy(func(in *ec2.DescribeInstancesInput) bool {
+		return len(in.InstanceIds) == 100
+	})).Return(&ec2.DescribeInstancesOutput{}, nil).Once()
+	e.On("DescribeInstancesPages", mock.MatchedBy(func(in *ec2.DescribeInstancesInput) bool {
+		return len(in.InstanceIds) == 50
+	})).Return(&ec2.DescribeInstancesOutput{}, nil).Once()
+
+	_, err := c.GroupInstances()
+	require.Nil(t, err)
+
+	e.AssertExpectations(t)
+}
+
+func TestClient_LoadName(t *testing.T) {
+	a := &ASGMock{}
+
+	c := &client{
+		asg:        a,
+		hostname:   "1.ec2.internal",
+		region:     "us-west-2",
+		instanceID: "1",
+	}
+
+	a.On("DescribeAutoScalingInstances", &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String("1")},
+	}).Return(&autoscaling.DescribeAutoScalingInstancesOutput{}, nil).Once()
+
+	_, err := c.group()
+	require.Equal(t, "aws: autoscaling group not found", err.Error())
+
+	a.On("DescribeAutoScalingInstances", &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String("1")},
+	}).Return(&autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{
+			{InstanceId: aws.String("1"), AutoScalingGroupName: aws.String("new")},
+		},
+	}, nil).Once()
+
+	// The name is looked up once and kept.
+	require.Equal(t, "new", c.GroupName())
+	require.Equal(t, "new", c.GroupName())
+
+	a.On("DescribeAutoScalingInstances", &autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{aws.String("1")},
+	}).Return(&autoscaling.DescribeAutoScalingInstancesOutput{
+		AutoScalingInstances: []*autoscaling.InstanceDetails{{
+			InstanceId:           aws.String("1"),
+			AutoScalingGroupName: aws.String("new"),
+			LifecycleState:       aws.String("Pending:Wait"),
+		}},
+	}, nil).Once()
+
+	state, err := c.LifecycleState()
+	require.Nil(t, err)
+	require.Equal(t, "Pending:Wait", state)
 
 	a.AssertExpectations(t)
 }
@@ -193,3 +478,90 @@ func TestClient_Upload(t *testing.T) {
 
 	s.AssertExpectations(t)
 }
+
+func TestClient_Download(t *testing.T) {
+	s := &S3Mock{}
+
+	c := &client{
+		s3:         s,
+		hostname:   "1.ec2.internal",
+		region:     "us-west-2",
+		instanceID: "1",
+		groupName:  "test",
+	}
+
+	s.On("GetObject", &s3.GetObjectInput{
+		Bucket: aws.String("test"),
+		Key:    aws.String("ca.pem"),
+	}).Return(&s3.GetObjectOutput{
+		Body: ioutil.NopCloser(bytes.NewBufferString("ca")),
+	}, nil)
+
+	data, err := c.Download("test", "ca.pem")
+	require.Nil(t, err)
+	require.Equal(t, "ca", string(data))
+
+	s.AssertExpectations(t)
+}
+
+func TestClient_Decrypt(t *testing.T) {
+	k := &KMSMock{}
+
+	c := &client{
+		kms:        k,
+		hostname:   "1.ec2.internal",
+		region:     "us-west-2",
+		instanceID: "1",
+		groupName:  "test",
+	}
+
+	k.On("Decrypt", &kms.DecryptInput{
+		CiphertextBlob: []byte("encrypted"),
+	}).Return(&kms.DecryptOutput{
+		Plaintext: []byte("plain"),
+	}, nil)
+
+	data, err := c.Decrypt([]byte("encrypted"))
+	require.Nil(t, err)
+	require.Equal(t, "plain", string(data))
+
+	k.AssertExpectations(t)
+}
+
+func TestClient_Secret(t *testing.T) {
+	s := &SSMMock{}
+
+	c := &client{
+		ssm:        s,
+		hostname:   "1.ec2.internal",
+		region:     "us-west-2",
+		instanceID: "1",
+		groupName:  "test",
+	}
+
+	s.On("GetParameter", &ssm.GetParameterInput{
+		Name:           aws.String("/etcd/ca.pem"),
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Value: aws.String("ssm-value")},
+	}, nil)
+	s.On("GetParameter", &ssm.GetParameterInput{
+		Name:           aws.String("/aws/reference/secretsmanager/etcd/ca"),
+		WithDecryption: aws.Bool(true),
+	}).Return(&ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Value: aws.String("secret-value")},
+	}, nil)
+
+	val, err := c.Secret("ssm:/etcd/ca.pem")
+	require.Nil(t, err)
+	require.Equal(t, "ssm-value", string(val))
+
+	val, err = c.Secret("secretsmanager:etcd/ca")
+	require.Nil(t, err)
+	require.Equal(t, "secret-value", string(val))
+
+	_, err = c.Secret("etcd/ca")
+	require.NotNil(t, err)
+
+	s.AssertExpectations(t)
+}
@@ -0,0 +1,64 @@
+package aws
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/settings"
+)
+
+type Config struct {
+	// MetadataEndpoint overrides the instance metadata service, for example
+	// with a local stand-in. It is the base URL without the /latest path.
+	MetadataEndpoint string
+	MetadataTokenTTL string
+	MetadataRetries  string
+
+	// Api requests are retried with exponential backoff and jitter between
+	// the base and max delay, throttled requests start from a longer delay.
+	MaxRetries     string
+	RetryBaseDelay string
+	RetryMaxDelay  string
+
+	// CacheTTL is how long instance lookups, secrets and target health are
+	// reused, which dedups the calls made within a reconcile.
+	CacheTTL string
+}
+
+// env resolves a setting from the flags, the environment or the config file.
+func env(name, defaults string) string {
+	return settings.Get(name, defaults)
+}
+
+func GetEnvConfig() Config {
+	return Config{
+		MetadataEndpoint: env("ETCD_AWS_METADATA_ENDPOINT", ""),
+		MetadataTokenTTL: env("ETCD_AWS_METADATA_TOKEN_TTL", "6h"),
+		MetadataRetries:  env("ETCD_AWS_METADATA_RETRIES", "3"),
+		MaxRetries:       env("ETCD_AWS_MAX_RETRIES", "8"),
+		RetryBaseDelay:   env("ETCD_AWS_RETRY_BASE_DELAY", "100ms"),
+		RetryMaxDelay:    env("ETCD_AWS_RETRY_MAX_DELAY", "20s"),
+		CacheTTL:         env("ETCD_AWS_CACHE_TTL", "15s"),
+	}
+}
+
+// durationOr parses a duration, an empty value uses the default.
+func durationOr(value string, defaults time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaults, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// countOr parses a non negative count, an empty value uses the default.
+func countOr(value string, defaults int) (int, error) {
+	if value == "" {
+		return defaults, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("aws: invalid count: %s", value)
+	}
+	return n, nil
+}
@@ -0,0 +1,103 @@
+package aws
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/defaults"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// roleCredentials is the default credential chain with the instance role
+// credentials fetched through meta, so that they are also token based.
+func roleCredentials(sess *session.Session, meta *ec2metadata.EC2Metadata, region string) *credentials.Credentials {
+	return credentials.NewChainCredentials(credentialProviders(sess, meta, region))
+}
+
+// credentialProviders returns the environment, shared file, web identity and
+// remote providers in the order of the sdk default chain. The remote provider
+// is the ECS task role when the container credential variables are set and
+// otherwise the instance role read through meta.
+func credentialProviders(sess *session.Session, meta *ec2metadata.EC2Metadata, region string) []credentials.Provider {
+	providers := []credentials.Provider{
+		&credentials.EnvProvider{},
+		&credentials.SharedCredentialsProvider{},
+	}
+	if p := webIdentityFromEnv(sess, region); p != nil {
+		providers = append(providers, p)
+	}
+	remote := defaults.RemoteCredProvider(*sess.Config, sess.Handlers)
+	if _, ok := remote.(*ec2rolecreds.EC2RoleProvider); ok {
+		remote = &ec2rolecreds.EC2RoleProvider{Client: meta, ExpiryWindow: 5 * time.Minute}
+	}
+	return append(providers, remote)
+}
+
+const webIdentityProviderName = "WebIdentityProvider"
+
+// webIdentityProvider assumes a role with the token in a file, as set up for
+// service accounts by EKS. The vendored sdk predates its own provider.
+type webIdentityProvider struct {
+	credentials.Expiry
+
+	client      *sts.STS
+	roleARN     string
+	sessionName string
+	tokenFile   string
+}
+
+// webIdentityFromEnv returns a web identity provider when AWS_ROLE_ARN and
+// AWS_WEB_IDENTITY_TOKEN_FILE are set.
+func webIdentityFromEnv(sess *session.Session, region string) credentials.Provider {
+	roleARN := os.Getenv("AWS_ROLE_ARN")
+	tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	if roleARN == "" || tokenFile == "" {
+		return nil
+	}
+	sessionName := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if sessionName == "" {
+		sessionName = "etcd-aws-cluster-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	}
+	return &webIdentityProvider{
+		client: sts.New(sess, &aws.Config{
+			Region:      aws.String(region),
+			Credentials: credentials.AnonymousCredentials,
+		}),
+		roleARN:     roleARN,
+		sessionName: sessionName,
+		tokenFile:   tokenFile,
+	}
+}
+
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, err
+	}
+	out, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.sessionName),
+		WebIdentityToken: aws.String(string(token)),
+	})
+	if err != nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, err
+	}
+	if out.Credentials == nil {
+		return credentials.Value{ProviderName: webIdentityProviderName}, errors.New("aws: web identity role returned no credentials")
+	}
+	p.SetExpiration(aws.TimeValue(out.Credentials.Expiration), 5*time.Minute)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(out.Credentials.SessionToken),
+		ProviderName:    webIdentityProviderName,
+	}, nil
+}
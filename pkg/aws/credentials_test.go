@@ -0,0 +1,89 @@
+package aws
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	meta, err := newMetadata(mockSession, Config{})
+	require.Nil(t, err)
+
+	providers := credentialProviders(mockSession, meta, "us-west-2")
+	require.Len(t, providers, 3)
+	role, ok := providers[2].(*ec2rolecreds.EC2RoleProvider)
+	require.True(t, ok)
+	require.True(t, role.Client == meta)
+
+	// The task role is used inside ECS.
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/v2/credentials/1")
+	defer os.Unsetenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+	providers = credentialProviders(mockSession, meta, "us-west-2")
+	require.Len(t, providers, 3)
+	_, ok = providers[2].(*ec2rolecreds.EC2RoleProvider)
+	require.False(t, ok)
+
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/etcd")
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/token")
+	defer os.Unsetenv("AWS_ROLE_ARN")
+	defer os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	providers = credentialProviders(mockSession, meta, "us-west-2")
+	require.Len(t, providers, 4)
+	web, ok := providers[2].(*webIdentityProvider)
+	require.True(t, ok)
+	require.Equal(t, "arn:aws:iam::123456789012:role/etcd", web.roleARN)
+	require.Equal(t, "/var/run/secrets/token", web.tokenFile)
+}
+
+func TestWebIdentityProvider_Retrieve(t *testing.T) {
+	f, err := ioutil.TempFile("", "token-")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("web-token")
+	f.Close()
+
+	var form map[string][]string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>AKID</AccessKeyId>
+      <SecretAccessKey>SECRET</SecretAccessKey>
+      <SessionToken>TOKEN</SessionToken>
+      <Expiration>2100-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`))
+	}))
+	defer s.Close()
+
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::123456789012:role/etcd")
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", f.Name())
+	os.Setenv("AWS_ROLE_SESSION_NAME", "etcd-1")
+	defer os.Unsetenv("AWS_ROLE_ARN")
+	defer os.Unsetenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	defer os.Unsetenv("AWS_ROLE_SESSION_NAME")
+
+	sess := session.Must(session.NewSession(&aws.Config{Endpoint: aws.String(s.URL)}))
+	p := webIdentityFromEnv(sess, "us-west-2")
+	require.NotNil(t, p)
+
+	v, err := p.Retrieve()
+	require.Nil(t, err)
+	require.Equal(t, "AKID", v.AccessKeyID)
+	require.Equal(t, "SECRET", v.SecretAccessKey)
+	require.Equal(t, "TOKEN", v.SessionToken)
+	require.False(t, p.IsExpired())
+	require.Equal(t, []string{"web-token"}, form["WebIdentityToken"])
+	require.Equal(t, []string{"etcd-1"}, form["RoleSessionName"])
+}
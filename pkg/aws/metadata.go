@@ -0,0 +1,181 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	metadataTokenHeader    = "X-aws-ec2-metadata-token"
+	metadataTokenTTLHeader = "X-aws-ec2-metadata-token-ttl-seconds"
+)
+
+// metadataBackoff is the base delay between token request attempts.
+var metadataBackoff = 200 * time.Millisecond
+
+// newMetadata creates a metadata client that uses IMDSv2 session tokens.
+func newMetadata(sess *session.Session, c Config) (*ec2metadata.EC2Metadata, error) {
+	ttl, err := durationOr(c.MetadataTokenTTL, 6*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if ttl < time.Second || ttl > 6*time.Hour {
+		return nil, fmt.Errorf("aws: metadata token ttl must be between 1s and 6h: %s", ttl)
+	}
+	retries, err := countOr(c.MetadataRetries, 3)
+	if err != nil {
+		return nil, err
+	}
+	tp := &metadataTransport{
+		ttl:     ttl,
+		retries: retries,
+		base: &http.Transport{
+			Dial:                  (&net.Dialer{Timeout: time.Second}).Dial,
+			ResponseHeaderTimeout: 5 * time.Second,
+		},
+	}
+	cfg := &aws.Config{
+		HTTPClient: &http.Client{Transport: tp},
+		MaxRetries: aws.Int(retries),
+	}
+	if c.MetadataEndpoint != "" {
+		endpoint := strings.TrimSuffix(c.MetadataEndpoint, "/")
+		if u, err := url.Parse(endpoint); err != nil || u.Host == "" {
+			return nil, fmt.Errorf("aws: invalid metadata endpoint: %s", c.MetadataEndpoint)
+		}
+		cfg.Endpoint = aws.String(endpoint + "/latest")
+	}
+	meta := ec2metadata.New(sess, cfg)
+	tp.tokenURL = meta.Endpoint + "/api/token"
+	return meta, nil
+}
+
+// errNoTokens is returned when the metadata service does not implement the
+// token api, in which case requests are sent without a token.
+var errNoTokens = errors.New("aws: metadata service does not support tokens")
+
+// metadataTransport adds an IMDSv2 session token to requests for the instance
+// metadata service. Tokens are cached until shortly before they expire.
+type metadataTransport struct {
+	tokenURL string
+	ttl      time.Duration
+	retries  int
+	base     http.RoundTripper
+
+	lock     sync.Mutex
+	token    string
+	expires  time.Time
+	noTokens bool
+}
+
+func (t *metadataTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := t.getToken()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.base.RoundTrip(withToken(req, token))
+		if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" || attempt > 0 {
+			return resp, err
+		}
+		// The token was rejected, retry once with a new one.
+		resp.Body.Close()
+		t.resetToken()
+	}
+}
+
+func (t *metadataTransport) resetToken() {
+	t.lock.Lock()
+	t.token = ""
+	t.lock.Unlock()
+}
+
+func (t *metadataTransport) getToken() (string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.noTokens {
+		return "", nil
+	}
+	if t.token != "" && time.Now().Before(t.expires) {
+		return t.token, nil
+	}
+	var err error
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * metadataBackoff)
+		}
+		var token string
+		var retry bool
+		token, retry, err = t.fetchToken()
+		if err == errNoTokens {
+			t.noTokens = true
+			return "", nil
+		}
+		if err == nil {
+			t.token = token
+			t.expires = time.Now().Add(t.ttl * 9 / 10)
+			return token, nil
+		}
+		if !retry {
+			break
+		}
+	}
+	// A response that is dropped on the way back usually means the hop limit
+	// of the instance is too low for the container network.
+	return "", fmt.Errorf("aws: metadata token request failed, check the metadata hop limit: %v", err)
+}
+
+func (t *metadataTransport) fetchToken() (token string, retry bool, err error) {
+	req, err := http.NewRequest("PUT", t.tokenURL, nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set(metadataTokenTTLHeader, strconv.Itoa(int(t.ttl/time.Second)))
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return "", true, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", true, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return string(body), false, nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return "", false, errNoTokens
+	case resp.StatusCode >= 500:
+		return "", true, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return "", false, fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// withToken returns a copy of req carrying the token, requests must not be
+// modified by a round tripper.
+func withToken(req *http.Request, token string) *http.Request {
+	if token == "" {
+		return req
+	}
+	out := new(http.Request)
+	*out = *req
+	out.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		out.Header[k] = v
+	}
+	out.Header.Set(metadataTokenHeader, token)
+	return out
+}
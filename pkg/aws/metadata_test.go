@@ -0,0 +1,170 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/require"
+)
+
+// metadataServer is a stand-in metadata service that requires tokens unless
+// noTokens is set. tokenErrors fails that many token requests first and
+// paths serves extra metadata paths.
+type metadataServer struct {
+	*httptest.Server
+
+	lock        sync.Mutex
+	noTokens    bool
+	tokenErrors int
+	token       string
+	tokens      int
+	ttl         string
+	paths       map[string]string
+}
+
+func newMetadataServer() *metadataServer {
+	s := &metadataServer{}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *metadataServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if r.URL.Path == "/latest/api/token" {
+		if s.noTokens {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if s.tokenErrors > 0 {
+			s.tokenErrors--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		s.tokens++
+		s.ttl = r.Header.Get(metadataTokenTTLHeader)
+		s.token = fmt.Sprintf("token-%d", s.tokens)
+		w.Write([]byte(s.token))
+		return
+	}
+	if !s.noTokens && (s.token == "" || r.Header.Get(metadataTokenHeader) != s.token) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/latest/meta-data/instance-id":
+		w.Write([]byte("i-1"))
+	case "/latest/meta-data/local-hostname":
+		w.Write([]byte("ip-10-0-0-1.ec2.internal"))
+	case "/latest/meta-data/local-ipv4":
+		w.Write([]byte("10.0.0.1"))
+	case "/latest/dynamic/instance-identity/document":
+		w.Write([]byte(`{"instanceId":"i-1","region":"us-west-2"}`))
+	default:
+		body, ok := s.paths[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}
+}
+
+func (s *metadataServer) rotate() {
+	s.lock.Lock()
+	s.token = ""
+	s.lock.Unlock()
+}
+
+func testMetadata(t *testing.T, c Config) *ec2metadata.EC2Metadata {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("test")}))
+	meta, err := newMetadata(sess, c)
+	require.Nil(t, err)
+	return meta
+}
+
+func TestMetadata_Token(t *testing.T) {
+	s := newMetadataServer()
+	defer s.Close()
+
+	meta := testMetadata(t, Config{MetadataEndpoint: s.URL, MetadataTokenTTL: "1h"})
+	id, err := meta.GetMetadata("instance-id")
+	require.Nil(t, err)
+	require.Equal(t, "i-1", id)
+	ip, err := meta.GetMetadata("local-ipv4")
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.1", ip)
+	require.Equal(t, 1, s.tokens)
+	require.Equal(t, "3600", s.ttl)
+
+	s.rotate()
+	id, err = meta.GetMetadata("instance-id")
+	require.Nil(t, err)
+	require.Equal(t, "i-1", id)
+	require.Equal(t, 2, s.tokens)
+}
+
+func TestMetadata_NoTokens(t *testing.T) {
+	s := newMetadataServer()
+	s.noTokens = true
+	defer s.Close()
+
+	meta := testMetadata(t, Config{MetadataEndpoint: s.URL})
+	id, err := meta.GetMetadata("instance-id")
+	require.Nil(t, err)
+	require.Equal(t, "i-1", id)
+}
+
+func TestMetadata_TokenRetries(t *testing.T) {
+	metadataBackoff = 0
+	s := newMetadataServer()
+	s.tokenErrors = 10
+	defer s.Close()
+
+	meta := testMetadata(t, Config{MetadataEndpoint: s.URL, MetadataRetries: "1"})
+	_, err := meta.GetMetadata("instance-id")
+	require.NotNil(t, err)
+
+	s.tokenErrors = 2
+	meta = testMetadata(t, Config{MetadataEndpoint: s.URL, MetadataRetries: "2"})
+	id, err := meta.GetMetadata("instance-id")
+	require.Nil(t, err)
+	require.Equal(t, "i-1", id)
+}
+
+func TestMetadata_Invalid(t *testing.T) {
+	_, err := newMetadata(mockSession, Config{MetadataTokenTTL: "7h"})
+	require.NotNil(t, err)
+	_, err = newMetadata(mockSession, Config{MetadataRetries: "-1"})
+	require.NotNil(t, err)
+	_, err = newMetadata(mockSession, Config{MetadataEndpoint: "::"})
+	require.NotNil(t, err)
+}
+
+func TestClient_LoadMetadata(t *testing.T) {
+	s := newMetadataServer()
+	defer s.Close()
+
+	var regions []string
+	createSession = func(cfgs ...*aws.Config) (*session.Session, error) {
+		sess := mockSession.Copy(cfgs...)
+		regions = append(regions, *sess.Config.Region)
+		return sess, nil
+	}
+	c, err := NewClient(Config{MetadataEndpoint: s.URL})
+	require.Nil(t, err)
+	require.Equal(t, "us-west-2", c.Region())
+	require.Equal(t, []string{"test", "us-west-2"}, regions)
+	require.Equal(t, 1, s.tokens)
+}
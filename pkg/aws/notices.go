@@ -0,0 +1,103 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// Sources of interruption notices.
+const (
+	NoticeSpot        = "spot"
+	NoticeMaintenance = "maintenance"
+)
+
+// maintenanceTimeLayout is the format of the scheduled event times.
+const maintenanceTimeLayout = "2 Jan 2006 15:04:05 MST"
+
+// Notice is a pending interruption of this instance, a spot interruption or
+// a scheduled maintenance event. Action is the spot action or the event code
+// and Time is when the interruption happens at the earliest.
+type Notice struct {
+	Source string
+	Action string
+	Time   time.Time
+}
+
+func (c *client) Notices() ([]Notice, error) {
+	var notices []Notice
+
+	spot, err := optionalMetadata(c.meta, "spot/instance-action")
+	if err != nil {
+		return nil, err
+	}
+	if spot != "" {
+		var action struct {
+			Action string    `json:"action"`
+			Time   time.Time `json:"time"`
+		}
+		err = json.Unmarshal([]byte(spot), &action)
+		if err != nil {
+			return nil, err
+		}
+		notices = append(notices, Notice{Source: NoticeSpot, Action: action.Action, Time: action.Time})
+	}
+
+	scheduled, err := optionalMetadata(c.meta, "events/maintenance/scheduled")
+	if err != nil {
+		return nil, err
+	}
+	if scheduled != "" {
+		var events []struct {
+			Code      string
+			NotBefore string
+			State     string
+		}
+		err = json.Unmarshal([]byte(scheduled), &events)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range events {
+			// Canceled and completed events stay listed for a while.
+			if e.State != "" && !strings.EqualFold(e.State, "active") {
+				continue
+			}
+			at, err := time.Parse(maintenanceTimeLayout, e.NotBefore)
+			if err != nil {
+				return nil, err
+			}
+			notices = append(notices, Notice{Source: NoticeMaintenance, Action: e.Code, Time: at.UTC()})
+		}
+	}
+	return notices, nil
+}
+
+// optionalMetadata reads a metadata path that only exists while there is
+// something to report, a missing path gives an empty value.
+func optionalMetadata(meta *ec2metadata.EC2Metadata, p string) (string, error) {
+	var body []byte
+	req := meta.NewRequest(&request.Operation{
+		Name:       "GetMetadata",
+		HTTPMethod: "GET",
+		HTTPPath:   path.Join("/", "meta-data", p),
+	}, nil, nil)
+	req.Handlers.Unmarshal.PushFront(func(r *request.Request) {
+		body, r.Error = ioutil.ReadAll(r.HTTPResponse.Body)
+		r.HTTPResponse.Body = ioutil.NopCloser(bytes.NewReader(body))
+	})
+	err := req.Send()
+	if err != nil {
+		if req.HTTPResponse != nil && req.HTTPResponse.StatusCode == http.StatusNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
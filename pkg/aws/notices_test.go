@@ -0,0 +1,41 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Notices(t *testing.T) {
+	s := newMetadataServer()
+	defer s.Close()
+	c := &client{meta: testMetadata(t, Config{MetadataEndpoint: s.URL})}
+
+	notices, err := c.Notices()
+	require.Nil(t, err)
+	require.Empty(t, notices)
+
+	s.paths = map[string]string{
+		"/latest/meta-data/spot/instance-action": `{"action": "terminate", "time": "2017-09-18T08:22:00Z"}`,
+		"/latest/meta-data/events/maintenance/scheduled": `[
+			{"Code": "system-reboot", "NotBefore": "21 Jan 2019 09:00:43 GMT", "State": "active"},
+			{"Code": "instance-stop", "NotBefore": "20 Jan 2019 09:00:43 GMT", "State": "canceled"}
+		]`,
+	}
+	notices, err = c.Notices()
+	require.Nil(t, err)
+	require.Equal(t, []Notice{
+		{Source: NoticeSpot, Action: "terminate", Time: time.Date(2017, 9, 18, 8, 22, 0, 0, time.UTC)},
+		{Source: NoticeMaintenance, Action: "system-reboot", Time: time.Date(2019, 1, 21, 9, 0, 43, 0, time.UTC)},
+	}, notices)
+
+	s.paths["/latest/meta-data/events/maintenance/scheduled"] = `[]`
+	notices, err = c.Notices()
+	require.Nil(t, err)
+	require.Len(t, notices, 1)
+
+	s.paths["/latest/meta-data/spot/instance-action"] = `not json`
+	_, err = c.Notices()
+	require.NotNil(t, err)
+}
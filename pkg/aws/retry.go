@@ -0,0 +1,64 @@
+package aws
+
+import (
+	"math/rand"
+	"strings"
+	"time"
+
+	awsclient "github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// retryer retries throttled and failed requests with capped exponential
+// backoff and jitter. Throttled requests back off from a longer base delay,
+// which spreads out the calls of many nodes restarting together.
+type retryer struct {
+	awsclient.DefaultRetryer
+	base time.Duration
+	max  time.Duration
+}
+
+func newRetryer(retries int, base, max time.Duration) retryer {
+	return retryer{
+		DefaultRetryer: awsclient.DefaultRetryer{NumMaxRetries: retries},
+		base:           base,
+		max:            max,
+	}
+}
+
+func (r retryer) ShouldRetry(req *request.Request) bool {
+	return isThrottle(req) || r.DefaultRetryer.ShouldRetry(req)
+}
+
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	base := r.base
+	if isThrottle(req) {
+		base *= 4
+	}
+	return backoff(base, r.max, req.RetryCount)
+}
+
+// backoff returns the delay before the given retry. The exponential delay is
+// capped at max and jittered, keeping at least half of it.
+func backoff(base, max time.Duration, attempt int) time.Duration {
+	if base <= 0 || max <= 0 {
+		return 0
+	}
+	delay := max
+	if attempt < 32 {
+		if d := base << uint(attempt); d > 0 && d < max {
+			delay = d
+		}
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func isThrottle(req *request.Request) bool {
+	if req.HTTPResponse != nil && req.HTTPResponse.StatusCode == 429 {
+		return true
+	}
+	if req.Error == nil {
+		return false
+	}
+	return request.IsErrorThrottle(req.Error) || strings.Contains(req.Error.Error(), "Rate exceeded")
+}
@@ -0,0 +1,53 @@
+package aws
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetry_Backoff(t *testing.T) {
+	base := 100 * time.Millisecond
+	max := 20 * time.Second
+	for attempt := 0; attempt < 64; attempt++ {
+		exp := max
+		if attempt < 8 {
+			exp = base << uint(attempt)
+		}
+		d := backoff(base, max, attempt)
+		require.True(t, d >= exp/2 && d <= exp, "attempt %d: %s", attempt, d)
+	}
+	require.Equal(t, time.Duration(0), backoff(0, max, 3))
+}
+
+func TestRetry_Throttle(t *testing.T) {
+	r := newRetryer(8, 100*time.Millisecond, 20*time.Second)
+
+	req := &request.Request{
+		HTTPResponse: &http.Response{StatusCode: 429},
+		Error:        errors.New("too many requests"),
+	}
+	require.True(t, isThrottle(req))
+	require.True(t, r.ShouldRetry(req))
+
+	req = &request.Request{
+		HTTPResponse: &http.Response{StatusCode: 400},
+		Error:        awserr.New("Throttling", "Rate exceeded", nil),
+	}
+	require.True(t, isThrottle(req))
+	require.True(t, r.ShouldRetry(req))
+	d := r.RetryRules(req)
+	require.True(t, d >= 200*time.Millisecond && d <= 400*time.Millisecond, d.String())
+
+	req = &request.Request{
+		HTTPResponse: &http.Response{StatusCode: 400},
+		Error:        awserr.New("ValidationError", "invalid", nil),
+	}
+	require.False(t, isThrottle(req))
+	require.False(t, r.ShouldRetry(req))
+}
@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+// Record is a Route53 resource record set. Names are without the trailing
+// dot.
+type Record struct {
+	Name   string
+	Type   string
+	TTL    int64
+	Values []string
+}
+
+// Zone manages the records of a Route53 hosted zone.
+type Zone interface {
+	Records() ([]Record, error)
+
+	// Change applies the upserts and deletes in a single change batch.
+	Change(upserts, deletes []Record) error
+}
+
+func (c *client) Zone(id string) Zone {
+	return &zone{api: c.r53, id: id}
+}
+
+type zone struct {
+	api route53iface.Route53API
+	id  string
+}
+
+// Records lists the plain record sets of the zone, alias records are
+// skipped as they cannot be managed as values.
+func (z *zone) Records() ([]Record, error) {
+	var out []Record
+	err := z.api.ListResourceRecordSetsPages(
+		&route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(z.id)},
+		func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+			for _, set := range page.ResourceRecordSets {
+				if set.AliasTarget != nil {
+					continue
+				}
+				r := Record{
+					Name: strings.TrimSuffix(aws.StringValue(set.Name), "."),
+					Type: aws.StringValue(set.Type),
+					TTL:  aws.Int64Value(set.TTL),
+				}
+				for _, v := range set.ResourceRecords {
+					r.Values = append(r.Values, aws.StringValue(v.Value))
+				}
+				out = append(out, r)
+			}
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (z *zone) Change(upserts, deletes []Record) error {
+	var changes []*route53.Change
+	for _, r := range deletes {
+		changes = append(changes, recordChange(route53.ChangeActionDelete, r))
+	}
+	for _, r := range upserts {
+		changes = append(changes, recordChange(route53.ChangeActionUpsert, r))
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	_, err := z.api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(z.id),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	})
+	return err
+}
+
+func recordChange(action string, r Record) *route53.Change {
+	set := &route53.ResourceRecordSet{
+		Name: aws.String(r.Name),
+		Type: aws.String(r.Type),
+		TTL:  aws.Int64(r.TTL),
+	}
+	for _, v := range r.Values {
+		set.ResourceRecords = append(set.ResourceRecords, &route53.ResourceRecord{Value: aws.String(v)})
+	}
+	return &route53.Change{Action: aws.String(action), ResourceRecordSet: set}
+}
@@ -0,0 +1,98 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type Route53Mock struct {
+	route53iface.Route53API
+	mock.Mock
+}
+
+func (m *Route53Mock) ListResourceRecordSetsPages(
+	in *route53.ListResourceRecordSetsInput,
+	fn func(*route53.ListResourceRecordSetsOutput, bool) bool) error {
+	a := m.Called(in)
+	fn(a.Get(0).(*route53.ListResourceRecordSetsOutput), true)
+	return a.Error(1)
+}
+
+func (m *Route53Mock) ChangeResourceRecordSets(in *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*route53.ChangeResourceRecordSetsOutput), a.Error(1)
+}
+
+func TestZone_Records(t *testing.T) {
+	r := &Route53Mock{}
+	c := &client{r53: r}
+
+	r.On("ListResourceRecordSetsPages", &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String("Z1"),
+	}).Return(&route53.ListResourceRecordSetsOutput{
+		ResourceRecordSets: []*route53.ResourceRecordSet{
+			{
+				Name:            aws.String("i-1.etcd.internal."),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(60),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}},
+			},
+			{
+				Name:        aws.String("lb.etcd.internal."),
+				Type:        aws.String("A"),
+				AliasTarget: &route53.AliasTarget{},
+			},
+		},
+	}, nil)
+
+	records, err := c.Zone("Z1").Records()
+	require.Nil(t, err)
+	require.Equal(t, []Record{
+		{Name: "i-1.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+	}, records)
+}
+
+func TestZone_Change(t *testing.T) {
+	r := &Route53Mock{}
+	c := &client{r53: r}
+
+	r.On("ChangeResourceRecordSets", &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String("Z1"),
+		ChangeBatch: &route53.ChangeBatch{Changes: []*route53.Change{
+			{
+				Action: aws.String("DELETE"),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String("i-2.etcd.internal"),
+					Type:            aws.String("A"),
+					TTL:             aws.Int64(60),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}},
+				},
+			},
+			{
+				Action: aws.String("UPSERT"),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String("i-1.etcd.internal"),
+					Type:            aws.String("A"),
+					TTL:             aws.Int64(60),
+					ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.1")}},
+				},
+			},
+		}},
+	}).Return(&route53.ChangeResourceRecordSetsOutput{}, nil).Once()
+
+	z := c.Zone("Z1")
+	err := z.Change(
+		[]Record{{Name: "i-1.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}}},
+		[]Record{{Name: "i-2.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}}},
+	)
+	require.Nil(t, err)
+
+	// Nothing to change does not call the api.
+	require.Nil(t, z.Change(nil, nil))
+	r.AssertExpectations(t)
+}
@@ -0,0 +1,107 @@
+package aws
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+)
+
+// Target health states that mean a target no longer receives traffic.
+const (
+	TargetStateDraining = elbv2.TargetHealthStateEnumDraining
+	TargetStateUnused   = elbv2.TargetHealthStateEnumUnused
+)
+
+// TargetGroup is a load balancer target group with instance targets.
+type TargetGroup interface {
+	// Targets returns the registered instance IDs with their health state.
+	Targets() (map[string]string, error)
+	Register(ids ...string) error
+
+	// Deregister deregisters the instances and waits up to the timeout for
+	// their connections to drain, a zero timeout does not wait.
+	Deregister(timeout time.Duration, ids ...string) error
+}
+
+func (c *client) TargetGroup(arn string) TargetGroup {
+	return &targetGroup{api: c.elb, arn: arn, cache: c.cache}
+}
+
+type targetGroup struct {
+	api   elbv2iface.ELBV2API
+	arn   string
+	cache *cache
+}
+
+func (g *targetGroup) Targets() (map[string]string, error) {
+	value, err := g.cache.get("targets/"+g.arn, func() (interface{}, error) {
+		return g.targets()
+	})
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for id, state := range value.(map[string]string) {
+		targets[id] = state
+	}
+	return targets, nil
+}
+
+func (g *targetGroup) targets() (map[string]string, error) {
+	out, err := g.api.DescribeTargetHealth(&elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(g.arn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, desc := range out.TargetHealthDescriptions {
+		if desc.Target == nil || desc.TargetHealth == nil {
+			continue
+		}
+		targets[aws.StringValue(desc.Target.Id)] = aws.StringValue(desc.TargetHealth.State)
+	}
+	return targets, nil
+}
+
+func (g *targetGroup) Register(ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	defer g.cache.invalidate("targets/" + g.arn)
+	_, err := g.api.RegisterTargets(&elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String(g.arn),
+		Targets:        targetDescriptions(ids),
+	})
+	return err
+}
+
+func (g *targetGroup) Deregister(timeout time.Duration, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	defer g.cache.invalidate("targets/" + g.arn)
+	_, err := g.api.DeregisterTargets(&elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String(g.arn),
+		Targets:        targetDescriptions(ids),
+	})
+	if err != nil || timeout <= 0 {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return g.api.WaitUntilTargetDeregisteredWithContext(ctx, &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String(g.arn),
+		Targets:        targetDescriptions(ids),
+	})
+}
+
+func targetDescriptions(ids []string) (out []*elbv2.TargetDescription) {
+	for _, id := range ids {
+		out = append(out, &elbv2.TargetDescription{Id: aws.String(id)})
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package aws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+	"github.com/aws/aws-sdk-go/service/elbv2/elbv2iface"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type ELBMock struct {
+	elbv2iface.ELBV2API
+	mock.Mock
+}
+
+func (m *ELBMock) DescribeTargetHealth(in *elbv2.DescribeTargetHealthInput) (*elbv2.DescribeTargetHealthOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*elbv2.DescribeTargetHealthOutput), a.Error(1)
+}
+
+func (m *ELBMock) RegisterTargets(in *elbv2.RegisterTargetsInput) (*elbv2.RegisterTargetsOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*elbv2.RegisterTargetsOutput), a.Error(1)
+}
+
+func (m *ELBMock) DeregisterTargets(in *elbv2.DeregisterTargetsInput) (*elbv2.DeregisterTargetsOutput, error) {
+	a := m.Called(in)
+	return a.Get(0).(*elbv2.DeregisterTargetsOutput), a.Error(1)
+}
+
+func (m *ELBMock) WaitUntilTargetDeregisteredWithContext(ctx aws.Context, in *elbv2.DescribeTargetHealthInput, opts ...request.WaiterOption) error {
+	return m.Called(in).Error(0)
+}
+
+func TestTargetGroup_Targets(t *testing.T) {
+	e := &ELBMock{}
+	c := &client{elb: e}
+
+	e.On("DescribeTargetHealth", &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String("arn"),
+	}).Return(&elbv2.DescribeTargetHealthOutput{
+		TargetHealthDescriptions: []*elbv2.TargetHealthDescription{
+			{
+				Target:       &elbv2.TargetDescription{Id: aws.String("i-1")},
+				TargetHealth: &elbv2.TargetHealth{State: aws.String("healthy")},
+			},
+			{
+				Target:       &elbv2.TargetDescription{Id: aws.String("i-2")},
+				TargetHealth: &elbv2.TargetHealth{State: aws.String("draining")},
+			},
+		},
+	}, nil)
+
+	targets, err := c.TargetGroup("arn").Targets()
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"i-1": "healthy", "i-2": TargetStateDraining}, targets)
+}
+
+func TestTargetGroup_Register(t *testing.T) {
+	e := &ELBMock{}
+	c := &client{elb: e}
+
+	targets := []*elbv2.TargetDescription{{Id: aws.String("i-1")}}
+	e.On("RegisterTargets", &elbv2.RegisterTargetsInput{
+		TargetGroupArn: aws.String("arn"),
+		Targets:        targets,
+	}).Return(&elbv2.RegisterTargetsOutput{}, nil).Once()
+	e.On("DeregisterTargets", &elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String("arn"),
+		Targets:        targets,
+	}).Return(&elbv2.DeregisterTargetsOutput{}, nil).Once()
+	e.On("WaitUntilTargetDeregisteredWithContext", &elbv2.DescribeTargetHealthInput{
+		TargetGroupArn: aws.String("arn"),
+		Targets:        targets,
+	}).Return(nil).Once()
+
+	g := c.TargetGroup("arn")
+	require.Nil(t, g.Register("i-1"))
+	require.Nil(t, g.Deregister(time.Minute, "i-1"))
+
+	// Without a timeout the drain is not waited for.
+	e.On("DeregisterTargets", &elbv2.DeregisterTargetsInput{
+		TargetGroupArn: aws.String("arn"),
+		Targets:        targets,
+	}).Return(&elbv2.DeregisterTargetsOutput{}, nil).Once()
+	require.Nil(t, g.Deregister(0, "i-1"))
+
+	// Nothing to do does not call the api.
+	require.Nil(t, g.Register())
+	require.Nil(t, g.Deregister(time.Minute))
+	e.AssertExpectations(t)
+}
@@ -0,0 +1,187 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+)
+
+// clusterMembers returns the voting members of the cluster this instance
+// runs in, including itself unless it is a proxy. Members of an existing
+// cluster are the active members, those of a new cluster the seeds.
+func clusterMembers(config *Config) map[string]string {
+	members := map[string]string{}
+	if config.AnyAvailable() {
+		for id, host := range config.ActiveMembers {
+			members[id] = host
+		}
+	} else {
+		for id, host := range config.SeedInstances {
+			if config.IsVoter(id) {
+				members[id] = host
+			}
+		}
+	}
+	if config.IsVoter(config.InstanceID) {
+		members[config.InstanceID] = config.InstanceHost
+	} else {
+		delete(members, config.InstanceID)
+	}
+	return members
+}
+
+// endpointsManifest lists the members for clients that only know the load
+// balancer. It holds no timestamp so it only changes with the members.
+type endpointsManifest struct {
+	Cluster   string           `json:"cluster,omitempty"`
+	Endpoints []string         `json:"endpoints"`
+	Members   []manifestMember `json:"members"`
+}
+
+type manifestMember struct {
+	Name      string `json:"name"`
+	ClientURL string `json:"clientURL"`
+	PeerURL   string `json:"peerURL"`
+}
+
+func newEndpointsManifest(config *Config) endpointsManifest {
+	m := endpointsManifest{Cluster: config.GroupName, Endpoints: []string{}, Members: []manifestMember{}}
+	members := clusterMembers(config)
+	for _, id := range sortedIDs(members) {
+		host := memberHost(config, members, id)
+		m.Endpoints = append(m.Endpoints, config.ClientURL(host))
+		m.Members = append(m.Members, manifestMember{
+			Name:      id,
+			ClientURL: config.ClientURL(host),
+			PeerURL:   config.PeerURL(host),
+		})
+	}
+	return m
+}
+
+// memberHost prefers the discovered host of a member's instance over the
+// host in its peer url.
+func memberHost(config *Config, members map[string]string, id string) string {
+	if host := config.Instances[id]; host != "" {
+		return host
+	}
+	return members[id]
+}
+
+func sortedIDs(m map[string]string) []string {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// etcdctlVars renders an env file for etcdctl with the member endpoints and
+// the client certificates.
+func etcdctlVars(config *Config) []byte {
+	m := newEndpointsManifest(config)
+	b := bytes.NewBuffer(nil)
+	fmt.Fprintln(b, "ETCDCTL_API=3")
+	fmt.Fprintf(b, "ETCDCTL_ENDPOINTS=%s\n", strings.Join(m.Endpoints, ","))
+	if config.ClientScheme == "https" {
+		fmt.Fprintf(b, "ETCDCTL_CACERT=%s\n", config.ClientCAFile)
+		fmt.Fprintf(b, "ETCDCTL_CERT=%s\n", config.ClientCertFile)
+		fmt.Fprintf(b, "ETCDCTL_KEY=%s\n", config.ClientKeyFile)
+	}
+	return b.Bytes()
+}
+
+// prometheusTargets is a Prometheus file_sd target group.
+type prometheusTargets struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// metricsTargets renders a Prometheus file_sd list of the member metrics
+// endpoints, the metrics port when set and the client port otherwise. The
+// client port is labeled with its scheme since it may be served over https.
+func metricsTargets(config *Config) ([]byte, error) {
+	port := config.MetricsPort
+	if port == "" {
+		port = config.ClientPort
+	}
+	group := prometheusTargets{Targets: []string{}}
+	members := clusterMembers(config)
+	for _, id := range sortedIDs(members) {
+		group.Targets = append(group.Targets, net.JoinHostPort(memberHost(config, members, id), port))
+	}
+	labels := map[string]string{}
+	if config.GroupName != "" {
+		labels["etcd_cluster"] = config.GroupName
+	}
+	if config.MetricsPort == "" {
+		labels["__scheme__"] = config.ClientScheme
+	}
+	if len(labels) > 0 {
+		group.Labels = labels
+	}
+	out, err := json.MarshalIndent([]prometheusTargets{group}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// writeArtifacts writes the files for consumers of the cluster and uploads
+// the endpoints manifest to S3 when it changed since the last upload.
+func (c *Controller) writeArtifacts(config *Config) error {
+	uid, gid, err := fileOwner(config.Config)
+	if err != nil {
+		return err
+	}
+	mode, err := parseFileMode(config.ArtifactFileMode, 0644)
+	if err != nil {
+		return err
+	}
+
+	if config.EtcdctlEnvFile != "" {
+		_, err = writeOwnedFile(config.EtcdctlEnvFile, etcdctlVars(config), mode, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.PrometheusSDFile != "" {
+		data, err := metricsTargets(config)
+		if err != nil {
+			return err
+		}
+		_, err = writeOwnedFile(config.PrometheusSDFile, data, mode, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.EndpointsFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(newEndpointsManifest(config), "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = writeOwnedFile(config.EndpointsFile, data, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+	if config.EndpointsBucket == "" || c.uploaded == string(data) {
+		return nil
+	}
+	log.Printf("uploading endpoints: s3://%s/%s", config.EndpointsBucket, config.EndpointsKey)
+	err = c.aws.Upload(config.EndpointsFile, config.EndpointsBucket, config.EndpointsKey)
+	if err != nil {
+		return err
+	}
+	c.uploaded = string(data)
+	return nil
+}
@@ -0,0 +1,161 @@
+package controller
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func artifactsConfig() *Config {
+	cfg := &Config{
+		InstanceID:       "3",
+		InstanceHost:     "10.0.0.3",
+		GroupName:        "etcd",
+		Instances:        map[string]string{"1": "10.0.0.1", "2": "10.0.0.2", "3": "10.0.0.3"},
+		AvailableMembers: map[string]bool{"1": true, "2": true},
+		ActiveMembers:    map[string]string{"1": "10.0.0.1", "2": "member-2"},
+	}
+	cfg.ClientScheme = "https"
+	cfg.ClientPort = "2379"
+	cfg.PeerScheme = "https"
+	cfg.PeerPort = "2380"
+	cfg.ClientCAFile = "/etc/etcd/certs/ca.pem"
+	cfg.ClientCertFile = "/etc/etcd/certs/etcd.pem"
+	cfg.ClientKeyFile = "/etc/etcd/certs/etcd-key.pem"
+	return cfg
+}
+
+func TestClusterMembers(t *testing.T) {
+	cfg := artifactsConfig()
+	require.Equal(t, map[string]string{"1": "10.0.0.1", "2": "member-2", "3": "10.0.0.3"}, clusterMembers(cfg))
+
+	cfg.Voters = map[string]bool{"1": true, "2": true}
+	require.Equal(t, map[string]string{"1": "10.0.0.1", "2": "member-2"}, clusterMembers(cfg))
+
+	cfg.AvailableMembers = map[string]bool{}
+	cfg.SeedInstances = map[string]string{"1": "10.0.0.1", "2": "10.0.0.2", "3": "10.0.0.3"}
+	require.Equal(t, map[string]string{"1": "10.0.0.1", "2": "10.0.0.2"}, clusterMembers(cfg))
+}
+
+func TestEtcdctlVars(t *testing.T) {
+	require.Equal(t, `ETCDCTL_API=3
+ETCDCTL_ENDPOINTS=https://10.0.0.1:2379,https://10.0.0.2:2379,https://10.0.0.3:2379
+ETCDCTL_CACERT=/etc/etcd/certs/ca.pem
+ETCDCTL_CERT=/etc/etcd/certs/etcd.pem
+ETCDCTL_KEY=/etc/etcd/certs/etcd-key.pem
+`, string(etcdctlVars(artifactsConfig())))
+
+	cfg := artifactsConfig()
+	cfg.ClientScheme = "http"
+	require.Equal(t, `ETCDCTL_API=3
+ETCDCTL_ENDPOINTS=http://10.0.0.1:2379,http://10.0.0.2:2379,http://10.0.0.3:2379
+`, string(etcdctlVars(cfg)))
+}
+
+func TestMetricsTargets(t *testing.T) {
+	cfg := artifactsConfig()
+	cfg.MetricsPort = "2381"
+	out, err := metricsTargets(cfg)
+	require.Nil(t, err)
+	require.Equal(t, `[
+  {
+    "targets": [
+      "10.0.0.1:2381",
+      "10.0.0.2:2381",
+      "10.0.0.3:2381"
+    ],
+    "labels": {
+      "etcd_cluster": "etcd"
+    }
+  }
+]
+`, string(out))
+
+	cfg.MetricsPort = ""
+	cfg.GroupName = ""
+	out, err = metricsTargets(cfg)
+	require.Nil(t, err)
+	require.Contains(t, string(out), `"10.0.0.1:2379"`)
+	require.Contains(t, string(out), `"__scheme__": "https"`)
+	require.NotContains(t, string(out), "etcd_cluster")
+
+	cfg.MetricsPort = "2381"
+	out, err = metricsTargets(cfg)
+	require.Nil(t, err)
+	require.NotContains(t, string(out), "labels")
+}
+
+func TestController_WriteArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &MockAWS{}
+	c := &Controller{aws: a}
+
+	cfg := artifactsConfig()
+	cfg.EtcdctlEnvFile = filepath.Join(dir, "etcdctl.env")
+	cfg.PrometheusSDFile = filepath.Join(dir, "etcd.json")
+	cfg.EndpointsFile = filepath.Join(dir, "endpoints.json")
+	cfg.EndpointsBucket = "bucket"
+	cfg.EndpointsKey = "etcd/endpoints.json"
+
+	a.On("Upload", cfg.EndpointsFile, "bucket", "etcd/endpoints.json").Return(errors.New("denied")).Once()
+	require.NotNil(t, c.writeArtifacts(cfg))
+
+	// A failed upload is retried on the next run, then skipped until the
+	// manifest changes.
+	a.On("Upload", cfg.EndpointsFile, "bucket", "etcd/endpoints.json").Return(nil).Twice()
+	require.Nil(t, c.writeArtifacts(cfg))
+	require.Nil(t, c.writeArtifacts(cfg))
+	a.AssertNumberOfCalls(t, "Upload", 2)
+
+	data, err := ioutil.ReadFile(cfg.EndpointsFile)
+	require.Nil(t, err)
+	require.Equal(t, `{
+  "cluster": "etcd",
+  "endpoints": [
+    "https://10.0.0.1:2379",
+    "https://10.0.0.2:2379",
+    "https://10.0.0.3:2379"
+  ],
+  "members": [
+    {
+      "name": "1",
+      "clientURL": "https://10.0.0.1:2379",
+      "peerURL": "https://10.0.0.1:2380"
+    },
+    {
+      "name": "2",
+      "clientURL": "https://10.0.0.2:2379",
+      "peerURL": "https://10.0.0.2:2380"
+    },
+    {
+      "name": "3",
+      "clientURL": "https://10.0.0.3:2379",
+      "peerURL": "https://10.0.0.3:2380"
+    }
+  ]
+}
+`, string(data))
+
+	delete(cfg.ActiveMembers, "2")
+	delete(cfg.Instances, "2")
+	require.Nil(t, c.writeArtifacts(cfg))
+	a.AssertNumberOfCalls(t, "Upload", 3)
+
+	for _, name := range []string{"etcdctl.env", "etcd.json"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.Nil(t, err)
+		require.NotContains(t, string(data), "10.0.0.2")
+	}
+
+	// Artifacts default to 0644 whatever the env file mode is.
+	info, err := os.Stat(cfg.EndpointsFile)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"io/ioutil"
+	"log"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/discovery"
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"github.com/coldog/etcd-aws-cluster/pkg/pki"
+)
+
+// certificateHosts returns the hosts this instance is reached by besides its
+// IP and private DNS name, which are the advertised host, the member record
+// name when records are managed in Route53 and the extra advertised hosts.
+func certificateHosts(cfg etcd.Config, d discovery.Discovery) []string {
+	hosts := []string{d.Host()}
+	record := memberRecordName(recordDomain(cfg), d.InstanceID(), d.Host())
+	if cfg.Route53ZoneID != "" {
+		hosts = append(hosts, record)
+	}
+	for _, host := range cfg.AdvertiseClientHosts {
+		switch host {
+		case hostPrivateIP, hostPrivateDNS:
+		case hostRecord:
+			if cfg.Route53ZoneID == "" && recordDomain(cfg) != "" {
+				hosts = append(hosts, record)
+			}
+		default:
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+type certFiles struct {
+	Name     string
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	Hosts    []string
+}
+
+// issueCertificates issues this instance's client and peer certificates from
+// the CA stored in S3 when they are missing, close to expiry or no longer
+// cover the instance addresses. Extra hosts, such as the advertised address,
+// are added to both certificates. A certificate is also reissued when its key
+// does not match it or it is not signed by the current CA. It does nothing
+// unless a CA bucket is set. The files get the env file owner.
+func issueCertificates(a aws.Client, cfg etcd.Config, extra ...string) error {
+	if cfg.CABucket == "" {
+		return nil
+	}
+	validity, err := time.ParseDuration(cfg.CertValidity)
+	if err != nil {
+		return err
+	}
+	renewBefore, err := time.ParseDuration(cfg.CertRenewBefore)
+	if err != nil {
+		return err
+	}
+
+	uid, gid, err := fileOwner(cfg)
+	if err != nil {
+		return err
+	}
+
+	hosts := append([]string{a.IP(), a.Hostname()}, extra...)
+	files := []certFiles{
+		{
+			Name:     "client",
+			CertFile: cfg.ClientCertFile,
+			KeyFile:  cfg.ClientKeyFile,
+			CAFile:   cfg.ClientCAFile,
+			Hosts:    append([]string{"127.0.0.1", "localhost"}, hosts...),
+		},
+		{
+			Name:     "peer",
+			CertFile: cfg.PeerCertFile,
+			KeyFile:  cfg.PeerKeyFile,
+			CAFile:   cfg.PeerCAFile,
+			Hosts:    hosts,
+		},
+	}
+
+	// The CA certificate is fetched on every run so certificates signed by
+	// a rotated CA are reissued. The CA key is only fetched to issue.
+	caPEM, err := a.Download(cfg.CABucket, cfg.CACertKey)
+	if err != nil {
+		return err
+	}
+	var ca *pki.CA
+	for _, f := range files {
+		if _, err = writeOwnedFile(f.CAFile, caPEM, 0644, uid, gid); err != nil {
+			return err
+		}
+		currentCert, _ := ioutil.ReadFile(f.CertFile)
+		currentKey, _ := ioutil.ReadFile(f.KeyFile)
+		if !pki.NeedsRenewal(currentCert, currentKey, caPEM, f.Hosts, renewBefore) {
+			continue
+		}
+		if ca == nil {
+			ca, err = loadCA(a, cfg, caPEM)
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Printf("issuing %s certificate: %s", f.Name, f.CertFile)
+		certPEM, keyPEM, err := ca.Issue(pki.Request{
+			CommonName: a.InstanceID(),
+			Hosts:      f.Hosts,
+			Validity:   validity,
+		})
+		if err != nil {
+			return err
+		}
+		// When the certificate fails to write after the key, the key no
+		// longer matches it and the pair is issued again on the next run.
+		if _, err = writeOwnedFile(f.KeyFile, keyPEM, 0600, uid, gid); err != nil {
+			return err
+		}
+		if _, err = writeOwnedFile(f.CertFile, certPEM, 0644, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadCA(a aws.Client, cfg etcd.Config, certPEM []byte) (*pki.CA, error) {
+	encryptedKey, err := a.Download(cfg.CABucket, cfg.CAKeyKey)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := a.Decrypt(encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	return pki.ParseCA(certPEM, keyPEM)
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"github.com/coldog/etcd-aws-cluster/pkg/pki"
+	"github.com/coldog/etcd-aws-cluster/pkg/pki/pkitest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	caPEM, caKeyPEM, err := pkitest.CA()
+	require.Nil(t, err)
+
+	a := &MockAWS{}
+	a.On("IP").Return("10.0.0.1")
+	a.On("Hostname").Return("ip-10-0-0-1.ec2.internal")
+	a.On("InstanceID").Return("i-1")
+	a.On("Download", "ca-bucket", "ca.pem").Return(caPEM, nil).Times(3)
+	a.On("Download", "ca-bucket", "ca-key.pem.encrypted").Return([]byte("encrypted"), nil).Once()
+	a.On("Decrypt", []byte("encrypted")).Return(caKeyPEM, nil).Once()
+
+	cfg := etcd.Config{
+		ClientCAFile:    filepath.Join(dir, "ca.pem"),
+		ClientCertFile:  filepath.Join(dir, "etcd.pem"),
+		ClientKeyFile:   filepath.Join(dir, "etcd-key.pem"),
+		PeerCAFile:      filepath.Join(dir, "peer-ca.pem"),
+		PeerCertFile:    filepath.Join(dir, "peer-etcd.pem"),
+		PeerKeyFile:     filepath.Join(dir, "peer-etcd-key.pem"),
+		CABucket:        "ca-bucket",
+		CACertKey:       "ca.pem",
+		CAKeyKey:        "ca-key.pem.encrypted",
+		CertValidity:    "48h",
+		CertRenewBefore: "1h",
+	}
+
+	require.Nil(t, issueCertificates(a, cfg, "i-1.etcd.internal"))
+
+	peerPEM, err := ioutil.ReadFile(cfg.PeerCertFile)
+	require.Nil(t, err)
+	peerKeyPEM, err := ioutil.ReadFile(cfg.PeerKeyFile)
+	require.Nil(t, err)
+	require.False(t, pki.NeedsRenewal(peerPEM, peerKeyPEM, caPEM, []string{"10.0.0.1", "ip-10-0-0-1.ec2.internal", "i-1.etcd.internal"}, time.Hour))
+
+	clientPEM, err := ioutil.ReadFile(cfg.ClientCertFile)
+	require.Nil(t, err)
+	clientKeyPEM, err := ioutil.ReadFile(cfg.ClientKeyFile)
+	require.Nil(t, err)
+	require.False(t, pki.NeedsRenewal(clientPEM, clientKeyPEM, caPEM, []string{"127.0.0.1", "10.0.0.1"}, time.Hour))
+
+	info, err := os.Stat(cfg.PeerKeyFile)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err := ioutil.ReadFile(cfg.ClientCAFile)
+	require.Nil(t, err)
+	require.Equal(t, caPEM, data)
+
+	// Valid certificates are left alone, so the CA key is only fetched once.
+	require.Nil(t, issueCertificates(a, cfg, "i-1.etcd.internal"))
+	a.AssertNumberOfCalls(t, "Decrypt", 1)
+
+	// A key left without its certificate is replaced with a matching pair.
+	require.Nil(t, ioutil.WriteFile(cfg.PeerKeyFile, clientKeyPEM, 0600))
+	a.On("Download", "ca-bucket", "ca-key.pem.encrypted").Return([]byte("encrypted"), nil).Once()
+	a.On("Decrypt", []byte("encrypted")).Return(caKeyPEM, nil).Once()
+	require.Nil(t, issueCertificates(a, cfg, "i-1.etcd.internal"))
+
+	peerPEM, err = ioutil.ReadFile(cfg.PeerCertFile)
+	require.Nil(t, err)
+	peerKeyPEM, err = ioutil.ReadFile(cfg.PeerKeyFile)
+	require.Nil(t, err)
+	require.NotEqual(t, clientKeyPEM, peerKeyPEM)
+	require.False(t, pki.NeedsRenewal(peerPEM, peerKeyPEM, caPEM, []string{"10.0.0.1"}, time.Hour))
+
+	a.AssertExpectations(t)
+}
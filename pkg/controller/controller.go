@@ -3,12 +3,15 @@ package controller
 import (
 	"bytes"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"log"
+	"strconv"
 	"text/template"
 	"time"
 
 	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/discovery"
 	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
 )
 
@@ -19,9 +22,24 @@ type Config struct {
 	InstanceHost string
 	GroupName    string
 
+	// ListenHost is the resolved listen address and ExtraClientHosts the
+	// resolved hosts advertised in addition to the instance host.
+	ListenHost       string
+	ExtraClientHosts []string
+
+	// Instances are the discovered instances that keep their membership,
+	// seed instances may form a new cluster and every discovered instance
+	// is listed with its lifecycle state.
 	Instances        map[string]string
+	InstanceIPs      map[string]string
+	SeedInstances    map[string]string
+	InstanceStates   map[string]string
 	AvailableMembers map[string]bool
 	ActiveMembers    map[string]string
+
+	// Voters are the instances that vote when the number of voting members
+	// is capped, nil when every instance votes.
+	Voters map[string]bool
 }
 
 func (cfg *Config) AnyAvailable() bool {
@@ -46,58 +64,92 @@ type RealizedConfig struct {
 	etcd.Config
 
 	ClusterState              string
+	Proxy                     string
 	InitialCluster            []string
+	DiscoverySRV              string
 	Name                      string
 	InitialAdvertisePeerURL   string
 	InitialAdvertiseClientURL string
 	ListenClientURL           string
 	ListenPeerURL             string
+	ListenMetricsURL          string
+}
+
+// ConfigVars renders the env file.
+func (r *RealizedConfig) ConfigVars() ([]byte, error) {
+	return envRenderer{}.Render(r)
 }
 
-func (r *RealizedConfig) ConfigVars() []byte {
+// secretTemplate quotes the credentials so quotes, backslashes or newlines in
+// a password can not break out of the value.
+var secretTemplate = template.Must(template.New("secret").Funcs(template.FuncMap{
+	"quote": strconv.Quote,
+}).Parse(`
+ETCDCTL_USER={{printf "%s:%s" .Username .Password | quote}}
+`))
+
+// SecretVars renders the values that must not end up in the general env
+// file, such as auth credentials.
+func (r *RealizedConfig) SecretVars() ([]byte, error) {
 	b := bytes.NewBuffer(nil)
-	const tpl = `
-ETCD_INITIAL_CLUSTER_STATE="{{.ClusterState}}"
-ETCD_NAME="{{.Name}}"
-ETCD_INITIAL_CLUSTER="{{range $i, $el := .InitialCluster}}{{if $i}},{{end}}{{$el}}{{end}}"
-ETCD_LISTEN_CLIENT_URLS="{{.ListenClientURL}}"
-ETCD_LISTEN_PEER_URLS="{{.ListenPeerURL}}"
-ETCD_INITIAL_ADVERTISE_PEER_URLS="{{.InitialAdvertisePeerURL}}"
-ETCD_ADVERTISE_CLIENT_URLS="{{.InitialAdvertiseClientURL}}"
-ETCD_TRUSTED_CA_FILE={{.ClientCAFile}}
-ETCD_CERT_FILE={{.ClientCertFile}}
-ETCD_KEY_FILE={{.ClientKeyFile}}
-ETCD_CLIENT_CERT_AUTH={{eq .ClientScheme "https"}}
-ETCD_PEER_TRUSTED_CA_FILE={{.PeerCAFile}}
-ETCD_PEER_CERT_FILE={{.PeerCertFile}}
-ETCD_PEER_KEY_FILE={{.PeerKeyFile}}
-ETCD_PEER_CLIENT_CERT_AUTH={{eq .PeerScheme "https"}}
-`
-	err := template.Must(template.New("").Parse(tpl)).Execute(b, r)
-	if err != nil {
-		panic(err)
-	}
-	return b.Bytes()
+	err := secretTemplate.Execute(b, r)
+	return b.Bytes(), err
 }
 
-func NewController(a aws.Client, e etcd.Client) *Controller {
-	return &Controller{aws: a, etcd: e}
+// NewController creates a controller. The aws client is only required when
+// aws backed features such as secrets or certificate issuance are enabled.
+func NewController(d discovery.Discovery, p discovery.Policy, a aws.Client, e etcd.Client) *Controller {
+	return &Controller{discovery: d, policy: p, aws: a, etcd: e}
 }
 
 type Controller struct {
-	aws  aws.Client
-	etcd etcd.Client
+	discovery discovery.Discovery
+	policy    discovery.Policy
+	aws       aws.Client
+	etcd      etcd.Client
+
+	// uploaded is the endpoints manifest last uploaded by this process.
+	uploaded string
 }
 
+// ErrNotSeed is returned by Run while there is no cluster to join and this
+// instance is not one of the seeds. Forming a cluster of its own would split
+// it from the cluster the seeds form, so it waits until it is a seed too.
+var ErrNotSeed = errors.New("controller: no cluster is available and this instance is not a seed yet")
+
 func (c *Controller) refreshConfig() (*Config, error) {
-	instances, err := c.aws.GroupInstances()
+	discovered, err := c.discovery.Instances()
 	if err != nil {
 		return nil, err
 	}
 
+	instances := map[string]string{}
+	ips := map[string]string{}
+	seeds := map[string]string{}
+	states := map[string]string{}
+	for id, inst := range discovered {
+		states[id] = inst.State
+		if c.policy.Keep(inst) {
+			instances[id] = inst.Host
+			if inst.IP != "" {
+				ips[id] = inst.IP
+			}
+		}
+		if c.policy.Seed(inst) && inst.Host != "" {
+			seeds[id] = inst.Host
+		}
+	}
+
 	availableMembers := map[string]bool{}
 	activeMembers := map[string]string{}
 	for id, host := range instances {
+		// Instances without an address still count as present, so their
+		// members are not removed, but they cannot be contacted.
+		if host == "" {
+			log.Printf("instance has no address: %s", id)
+			availableMembers[id] = false
+			continue
+		}
 		available := c.etcd.IsAvailable(host)
 		availableMembers[id] = available
 
@@ -114,25 +166,36 @@ func (c *Controller) refreshConfig() (*Config, error) {
 
 	next := &Config{
 		Config:           c.etcd.Config(),
-		InstanceID:       c.aws.InstanceID(),
-		GroupName:        c.aws.GroupName(),
-		InstanceHost:     c.aws.IP(),
+		InstanceID:       c.discovery.InstanceID(),
+		GroupName:        c.discovery.Name(),
+		InstanceHost:     c.discovery.Host(),
 		Instances:        instances,
+		InstanceIPs:      ips,
+		SeedInstances:    seeds,
+		InstanceStates:   states,
 		AvailableMembers: availableMembers,
 		ActiveMembers:    activeMembers,
 	}
+	err = c.resolveHosts(next, discovered[next.InstanceID].IP)
+	if err != nil {
+		return nil, err
+	}
 	return next, nil
 }
 
 func (c *Controller) getRealizedConfig(config *Config) *RealizedConfig {
+	localhost := listenLocalhost(config.ListenLocalhost)
 	realized := &RealizedConfig{
 		Config:                    config.Config,
 		Name:                      config.InstanceID,
-		ListenClientURL:           config.ClientURL("0.0.0.0"),
-		ListenPeerURL:             config.PeerURL("0.0.0.0"),
-		InitialAdvertiseClientURL: config.ClientURL(config.InstanceHost),
+		ListenClientURL:           listenURLs(config, config.ClientURL, localhost),
+		ListenPeerURL:             listenURLs(config, config.PeerURL, false),
+		InitialAdvertiseClientURL: advertiseClientURLs(config),
 		InitialAdvertisePeerURL:   config.PeerURL(config.InstanceHost),
 	}
+	if config.MetricsPort != "" {
+		realized.ListenMetricsURL = listenURLs(config, metricsURL(config.MetricsPort), localhost)
+	}
 
 	// If any are available, join an existing cluster.
 	if config.AnyAvailable() {
@@ -144,8 +207,27 @@ func (c *Controller) getRealizedConfig(config *Config) *RealizedConfig {
 		realized.ClusterState = "existing"
 		realized.InitialCluster = config.PeerURLs(members)
 	} else {
+		// Only seed instances form a new cluster, Run makes sure this
+		// instance is one of them so that every seed renders the same
+		// initial cluster.
+		seeds := map[string]string{}
+		for k, v := range config.SeedInstances {
+			if config.IsVoter(k) {
+				seeds[k] = v
+			}
+		}
 		realized.ClusterState = "new"
-		realized.InitialCluster = config.PeerURLs(config.Instances)
+		realized.InitialCluster = config.PeerURLs(seeds)
+	}
+
+	if !config.IsVoter(config.InstanceID) {
+		proxyConfig(config, realized)
+	}
+
+	// With the srv bootstrap etcd reads the peers from the SRV records kept
+	// in the zone, it rejects an explicit initial cluster alongside them.
+	if config.Bootstrap == "srv" {
+		realized.DiscoverySRV = recordDomain(config.Config)
 	}
 	return realized
 }
@@ -160,6 +242,23 @@ func (c *Controller) getRemovalCandidates(config *Config) (out []string) {
 }
 
 func (c *Controller) Run() error {
+	left, err := hasLeft(c.etcd.Config().EnvFile)
+	if err != nil {
+		return err
+	}
+	if left {
+		return fmt.Errorf("controller: this member left the cluster, clear its data dir and remove %s to rejoin", c.etcd.Config().EnvFile)
+	}
+
+	err = syncSecrets(c.aws, c.etcd.Config())
+	if err != nil {
+		return err
+	}
+	err = issueCertificates(c.aws, c.etcd.Config(), certificateHosts(c.etcd.Config(), c.discovery)...)
+	if err != nil {
+		return err
+	}
+
 	config, err := c.refreshConfig()
 	if err != nil {
 		return err
@@ -168,10 +267,18 @@ func (c *Controller) Run() error {
 	log.Printf("starting run")
 	logConfig(config)
 
+	if _, ok := config.SeedInstances[config.InstanceID]; !ok && !config.AnyAvailable() {
+		return ErrNotSeed
+	}
+
 	configFile := c.etcd.Config().EnvFile
 
 	if config.AnyAvailable() {
 		toRemove := c.getRemovalCandidates(config)
+		err = c.deregisterTargets(config, toRemove)
+		if err != nil {
+			return err
+		}
 		for _, id := range toRemove {
 			log.Printf("removing etcd node: %s", id)
 			err = c.etcd.Remove(config.AnyAvailableHost(), id)
@@ -182,33 +289,155 @@ func (c *Controller) Run() error {
 		}
 	}
 
+	max, err := maxVoters(config.MaxVoters)
+	if err != nil {
+		return err
+	}
+	config.assignVoters(max)
+
 	log.Println("finding realized config")
 	realized := c.getRealizedConfig(config)
 	logConfig(realized)
 
-	if config.AnyAvailable() && !config.AvailableMembers[config.InstanceID] {
-		log.Printf("adding self to cluster: %s", config.InstanceHost)
-		err = c.etcd.Add(config.AnyAvailableHost(), config.InstanceHost)
+	// A running proxy answers like a member, so with a cap the membership
+	// decides whether this instance still has to join. A promoted proxy
+	// keeps answering until etcd is restarted with the member config.
+	_, member := config.ActiveMembers[config.InstanceID]
+	promoted := config.Voters != nil && config.IsVoter(config.InstanceID) &&
+		config.AvailableMembers[config.InstanceID] && !member
+	switch {
+	case !config.IsVoter(config.InstanceID):
+		log.Printf("voting members are at the cap of %d, running as a proxy", max)
+	case config.AnyAvailable() && (!config.AvailableMembers[config.InstanceID] || promoted):
+		err = c.join(config)
 		if err != nil {
 			return err
 		}
 	}
 
+	// The outputs are written before the records and targets are synced,
+	// so a failing aws api never keeps a new member from its config.
 	log.Printf("writing config: %s", configFile)
-	return ioutil.WriteFile(configFile, realized.ConfigVars(), 0700)
+	err = c.writeEnvFiles(config, realized)
+	if err != nil {
+		return err
+	}
+	if promoted {
+		err = restartPromoted(config)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.writeArtifacts(config)
+	if err != nil {
+		return err
+	}
+
+	err = c.syncRecords(config)
+	if err != nil {
+		return err
+	}
+	return c.syncTargets(config)
 }
 
-func (c *Controller) Watch(interval time.Duration) {
+func (c *Controller) writeEnvFiles(config *Config, realized *RealizedConfig) error {
+	cfg := realized.Config
+	uid, gid, err := fileOwner(cfg)
+	if err != nil {
+		return err
+	}
+	mode, err := parseFileMode(cfg.EnvFileMode, 0700)
+	if err != nil {
+		return err
+	}
+	vars, err := realized.ConfigVars()
+	if err != nil {
+		return err
+	}
+	_, err = writeOwnedFile(cfg.EnvFile, vars, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+
+	outputs, err := parseOutputs(cfg.Outputs)
+	if err != nil {
+		return err
+	}
+	for _, out := range outputs {
+		renderer, err := outputRenderer(out.format, config)
+		if err != nil {
+			return err
+		}
+		data, err := renderer.Render(realized)
+		if err != nil {
+			return fmt.Errorf("controller: failed to render %s output %s: %v", out.format, out.path, err)
+		}
+		log.Printf("writing %s config: %s", out.format, out.path)
+		_, err = writeOwnedFile(out.path, data, mode, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if cfg.SecretEnvFile == "" || cfg.Username == "" {
+		return nil
+	}
+	mode, err = parseFileMode(cfg.SecretEnvFileMode, 0600)
+	if err != nil {
+		return err
+	}
+	log.Printf("writing secret config: %s", cfg.SecretEnvFile)
+	vars, err = realized.SecretVars()
+	if err != nil {
+		return err
+	}
+	_, err = writeOwnedFile(cfg.SecretEnvFile, vars, mode, uid, gid)
+	return err
+}
+
+// Watch runs the controller every interval. With an aws client and a notice
+// interval it also polls for interruption notices and leaves the cluster
+// ahead of them, maintenance events once they are within leaveBefore. The
+// polling stops once the member has left.
+func (c *Controller) Watch(interval, noticeInterval, leaveBefore time.Duration) {
 	t := time.NewTicker(interval)
 	defer t.Stop()
-	for range t.C {
-		err := c.Run()
-		if err != nil {
-			log.Printf("run failed: %v", err)
+
+	var (
+		notices <-chan time.Time
+		n       *time.Ticker
+	)
+	if c.aws != nil && noticeInterval > 0 {
+		n = time.NewTicker(noticeInterval)
+		defer n.Stop()
+		notices = n.C
+	}
+
+	for {
+		select {
+		case <-t.C:
+			err := c.Run()
+			if err != nil {
+				log.Printf("run failed: %v", err)
+			}
+		case <-notices:
+			if c.checkNotices(leaveBefore) {
+				n.Stop()
+				notices = nil
+			}
 		}
 	}
 }
 
+// ParseInterval parses a polling interval or leave window. An empty or zero
+// value is 0, which disables the polling.
+func ParseInterval(value string) (time.Duration, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
 func logConfig(c interface{}) {
 	out, err := json.MarshalIndent(c, "", "    ")
 	if err != nil {
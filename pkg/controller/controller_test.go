@@ -2,9 +2,13 @@ package controller
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 
 	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/discovery"
 	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -24,6 +28,16 @@ var etcdTestConfig = etcd.Config{
 	PeerPort:     "2379",
 }
 
+// asgDiscovery is the discovery backend of an autoscaling group with the
+// default private IP addresses.
+func asgDiscovery(a aws.Client) discovery.Discovery {
+	d, err := discovery.New(discovery.Config{Backend: "asg"}, a)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
 type MockAWS struct {
 	aws.Client
 	mock.Mock
@@ -35,9 +49,54 @@ func (m *MockAWS) Region() string     { return m.Called().String(0) }
 func (m *MockAWS) InstanceID() string { return m.Called().String(0) }
 func (m *MockAWS) GroupName() string  { return m.Called().String(0) }
 
-func (m *MockAWS) GroupInstances() (map[string]string, error) {
+func (m *MockAWS) GroupInstances() (map[string]aws.Instance, error) {
+	a := m.Called()
+	return a.Get(0).(map[string]aws.Instance), a.Error(1)
+}
+
+func (m *MockAWS) LifecycleState() (string, error) {
+	a := m.Called()
+	return a.String(0), a.Error(1)
+}
+
+func (m *MockAWS) Notices() ([]aws.Notice, error) {
 	a := m.Called()
-	return a.Get(0).(map[string]string), a.Error(1)
+	return a.Get(0).([]aws.Notice), a.Error(1)
+}
+
+func inService(hosts map[string]string) map[string]aws.Instance {
+	out := map[string]aws.Instance{}
+	for id, host := range hosts {
+		out[id] = aws.Instance{IP: host, LifecycleState: "InService", HealthStatus: "Healthy"}
+	}
+	return out
+}
+
+func (m *MockAWS) Secret(ref string) ([]byte, error) {
+	a := m.Called(ref)
+	return a.Get(0).([]byte), a.Error(1)
+}
+
+func (m *MockAWS) Upload(filename, bucket, key string) error {
+	return m.Called(filename, bucket, key).Error(0)
+}
+
+func (m *MockAWS) Download(bucket, key string) ([]byte, error) {
+	a := m.Called(bucket, key)
+	return a.Get(0).([]byte), a.Error(1)
+}
+
+func (m *MockAWS) Decrypt(data []byte) ([]byte, error) {
+	a := m.Called(data)
+	return a.Get(0).([]byte), a.Error(1)
+}
+
+func (m *MockAWS) Zone(id string) aws.Zone {
+	return m.Called(id).Get(0).(aws.Zone)
+}
+
+func (m *MockAWS) TargetGroup(arn string) aws.TargetGroup {
+	return m.Called(arn).Get(0).(aws.TargetGroup)
 }
 
 type MockETCD struct {
@@ -65,6 +124,19 @@ func (m *MockETCD) Members(hostname string) (map[string]string, error) {
 	return a.Get(0).(map[string]string), nil
 }
 
+func (m *MockETCD) HasPeer(clientHostname, candidateHostname string) (bool, error) {
+	a := m.Called(clientHostname, candidateHostname)
+	return a.Bool(0), a.Error(1)
+}
+
+func (m *MockETCD) Healthy(hostname string) bool {
+	return m.Called(hostname).Bool(0)
+}
+
+func (m *MockETCD) MoveLeader(clientHostname, name string) error {
+	return m.Called(clientHostname, name).Error(0)
+}
+
 func TestConfig_Available(t *testing.T) {
 	c := &Config{
 		AvailableMembers: map[string]bool{
@@ -84,17 +156,18 @@ func TestController_NewClusterGetConfig(t *testing.T) {
 	e := &MockETCD{}
 
 	c := &Controller{
-		aws:  a,
-		etcd: e,
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
 	}
 
 	a.On("InstanceID").Return("1")
 	a.On("IP").Return("1.ec2.internal")
 	a.On("GroupName").Return("test")
-	a.On("GroupInstances").Return(map[string]string{
+	a.On("GroupInstances").Return(inService(map[string]string{
 		"1": "1.ec2.internal",
 		"2": "2.ec2.internal",
-	}, nil)
+	}), nil)
 
 	e.On("IsAvailable", "1.ec2.internal").Return(false)
 	e.On("IsAvailable", "2.ec2.internal").Return(false)
@@ -109,6 +182,18 @@ func TestController_NewClusterGetConfig(t *testing.T) {
 			"1": "1.ec2.internal",
 			"2": "2.ec2.internal",
 		},
+		InstanceIPs: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+		},
+		SeedInstances: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+		},
+		InstanceStates: map[string]string{
+			"1": "InService",
+			"2": "InService",
+		},
 		AvailableMembers: map[string]bool{
 			"1": false,
 			"2": false,
@@ -131,6 +216,10 @@ func TestController_NewClusterRealized(t *testing.T) {
 			"1": "1.ec2.internal",
 			"2": "2.ec2.internal",
 		},
+		SeedInstances: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+		},
 		AvailableMembers: map[string]bool{
 			"1": false,
 			"2": false,
@@ -173,7 +262,7 @@ ETCD_PEER_CERT_FILE=
 ETCD_PEER_KEY_FILE=
 ETCD_PEER_CLIENT_CERT_AUTH=true
 `
-	require.Equal(t, expectedVars, string(realized.ConfigVars()))
+	require.Equal(t, expectedVars, configVars(t, realized))
 }
 
 func TestController_NewClusterRemoval(t *testing.T) {
@@ -201,17 +290,18 @@ func TestController_NewClusterRun(t *testing.T) {
 	e := &MockETCD{}
 
 	c := &Controller{
-		aws:  a,
-		etcd: e,
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
 	}
 
 	a.On("InstanceID").Return("1")
 	a.On("IP").Return("1.ec2.internal")
 	a.On("GroupName").Return("test")
-	a.On("GroupInstances").Return(map[string]string{
+	a.On("GroupInstances").Return(inService(map[string]string{
 		"1": "1.ec2.internal",
 		"2": "2.ec2.internal",
-	}, nil)
+	}), nil)
 
 	e.On("IsAvailable", "1.ec2.internal").Return(false)
 	e.On("IsAvailable", "2.ec2.internal").Return(false)
@@ -248,17 +338,18 @@ func TestController_ExistingClusterGetConfig(t *testing.T) {
 	e := &MockETCD{}
 
 	c := &Controller{
-		aws:  a,
-		etcd: e,
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
 	}
 
 	a.On("InstanceID").Return("1")
 	a.On("IP").Return("1.ec2.internal")
 	a.On("GroupName").Return("test")
-	a.On("GroupInstances").Return(map[string]string{
+	a.On("GroupInstances").Return(inService(map[string]string{
 		"1": "1.ec2.internal",
 		"2": "2.ec2.internal",
-	}, nil)
+	}), nil)
 
 	e.On("IsAvailable", "1.ec2.internal").Return(true)
 	e.On("IsAvailable", "2.ec2.internal").Return(false)
@@ -279,6 +370,18 @@ func TestController_ExistingClusterGetConfig(t *testing.T) {
 			"1": "1.ec2.internal",
 			"2": "2.ec2.internal",
 		},
+		InstanceIPs: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+		},
+		SeedInstances: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+		},
+		InstanceStates: map[string]string{
+			"1": "InService",
+			"2": "InService",
+		},
 		AvailableMembers: map[string]bool{
 			"1": true,
 			"2": false,
@@ -349,7 +452,7 @@ ETCD_PEER_CERT_FILE=
 ETCD_PEER_KEY_FILE=
 ETCD_PEER_CLIENT_CERT_AUTH=true
 `
-	require.Equal(t, expectedVars, string(realized.ConfigVars()))
+	require.Equal(t, expectedVars, configVars(t, realized))
 }
 
 func TestController_NeedsRemoval(t *testing.T) {
@@ -378,17 +481,18 @@ func TestController_ExistingClusterRun(t *testing.T) {
 	e := &MockETCD{}
 
 	c := &Controller{
-		aws:  a,
-		etcd: e,
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
 	}
 
 	a.On("InstanceID").Return("1")
 	a.On("IP").Return("1.ec2.internal")
 	a.On("GroupName").Return("test")
-	a.On("GroupInstances").Return(map[string]string{
+	a.On("GroupInstances").Return(inService(map[string]string{
 		"1": "1.ec2.internal",
 		"2": "2.ec2.internal",
-	}, nil)
+	}), nil)
 
 	e.On("IsAvailable", "1.ec2.internal").Return(false)
 	e.On("IsAvailable", "2.ec2.internal").Return(true)
@@ -401,6 +505,7 @@ func TestController_ExistingClusterRun(t *testing.T) {
 		"1": "1.ec2.internal",
 		"2": "2.ec2.internal",
 	}, nil)
+	e.On("HasPeer", "2.ec2.internal", "1.ec2.internal").Return(false, nil)
 	e.On("Add", "2.ec2.internal", "1.ec2.internal").Return(nil)
 
 	err := c.Run()
@@ -434,17 +539,18 @@ func TestController_ExistingClusterRemovalRun(t *testing.T) {
 	e := &MockETCD{}
 
 	c := &Controller{
-		aws:  a,
-		etcd: e,
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
 	}
 
 	a.On("InstanceID").Return("1")
 	a.On("IP").Return("1.ec2.internal")
 	a.On("GroupName").Return("test")
-	a.On("GroupInstances").Return(map[string]string{
+	a.On("GroupInstances").Return(inService(map[string]string{
 		"1": "1.ec2.internal",
 		"2": "2.ec2.internal",
-	}, nil)
+	}), nil)
 
 	e.On("IsAvailable", "1.ec2.internal").Return(true)
 	e.On("IsAvailable", "2.ec2.internal").Return(true)
@@ -544,5 +650,187 @@ ETCD_PEER_CERT_FILE=/etc/etcd/certs/peer-etcd.pem
 ETCD_PEER_KEY_FILE=/etc/etcd/certs/peer-etcd-key.pem
 ETCD_PEER_CLIENT_CERT_AUTH=true
 `
-	require.Equal(t, expectedVars, string(realized.ConfigVars()))
+	require.Equal(t, expectedVars, configVars(t, realized))
+}
+
+func TestController_WriteEnvFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "env-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := etcdTestConfig
+	cfg.EnvFile = filepath.Join(dir, "config")
+	cfg.EnvFileMode = "0644"
+	cfg.EnvFileUID = strconv.Itoa(os.Getuid())
+	cfg.EnvFileGID = strconv.Itoa(os.Getgid())
+	cfg.Username = "root"
+	cfg.Password = "secret"
+	cfg.SecretEnvFile = filepath.Join(dir, "secret")
+	cfg.SecretEnvFileMode = "0600"
+
+	realized := &RealizedConfig{Config: cfg, Name: "1"}
+	require.Nil(t, (&Controller{}).writeEnvFiles(&Config{Config: cfg}, realized))
+
+	info, err := os.Stat(cfg.EnvFile)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0644), info.Mode().Perm())
+
+	data, err := ioutil.ReadFile(cfg.EnvFile)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "secret")
+
+	info, err = os.Stat(cfg.SecretEnvFile)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data, err = ioutil.ReadFile(cfg.SecretEnvFile)
+	require.Nil(t, err)
+	require.Equal(t, "\nETCDCTL_USER=\"root:secret\"\n", string(data))
+
+	realized.Password = "se\"cr\\et\nX=1"
+	data, err = realized.SecretVars()
+	require.Nil(t, err)
+	require.Equal(t, "\nETCDCTL_USER=\"root:se\\\"cr\\\\et\\nX=1\"\n", string(data))
+}
+
+func TestController_LifecycleStates(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+
+	c := &Controller{
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
+	}
+
+	a.On("InstanceID").Return("1")
+	a.On("IP").Return("1.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(map[string]aws.Instance{
+		"1": {IP: "1.ec2.internal", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"2": {IP: "2.ec2.internal", LifecycleState: "Standby", HealthStatus: "Healthy"},
+		"3": {IP: "3.ec2.internal", LifecycleState: "Pending", HealthStatus: "Healthy"},
+		"4": {IP: "4.ec2.internal", LifecycleState: "Terminating:Wait", HealthStatus: "Healthy"},
+	}, nil)
+
+	e.On("IsAvailable", "1.ec2.internal").Return(false)
+	e.On("IsAvailable", "2.ec2.internal").Return(true)
+	e.On("IsAvailable", "3.ec2.internal").Return(false)
+	e.On("Config").Return(etcdTestConfig)
+	e.On("Members", "2.ec2.internal").Return(map[string]string{
+		"2": "2.ec2.internal",
+		"4": "4.ec2.internal",
+	}, nil)
+
+	config, err := c.refreshConfig()
+	require.Nil(t, err)
+
+	require.Equal(t, map[string]string{
+		"1": "InService",
+		"2": "Standby",
+		"3": "Pending",
+		"4": "Terminating:Wait",
+	}, config.InstanceStates)
+	require.Equal(t, map[string]string{"1": "1.ec2.internal"}, config.SeedInstances)
+	require.NotContains(t, config.Instances, "4")
+
+	// Standby keeps its membership while terminating instances depart.
+	require.Equal(t, []string{"4"}, c.getRemovalCandidates(config))
+
+	// A new cluster is only seeded from in service instances.
+	config.AvailableMembers = map[string]bool{}
+	realized := c.getRealizedConfig(config)
+	require.Equal(t, []string{"1=https://1.ec2.internal:2379"}, realized.InitialCluster)
+
+	e.AssertNotCalled(t, "IsAvailable", "4.ec2.internal")
+}
+
+func TestController_InstanceWithoutAddress(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+
+	c := &Controller{
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
+	}
+
+	a.On("InstanceID").Return("1")
+	a.On("IP").Return("1.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(inService(map[string]string{
+		"1": "1.ec2.internal",
+		"2": "",
+	}), nil)
+
+	e.On("IsAvailable", "1.ec2.internal").Return(true)
+	e.On("Config").Return(etcdTestConfig)
+	e.On("Members", "1.ec2.internal").Return(map[string]string{
+		"1": "1.ec2.internal",
+		"2": "2.ec2.internal",
+	}, nil)
+
+	config, err := c.refreshConfig()
+	require.Nil(t, err)
+	require.False(t, config.AvailableMembers["2"])
+	require.NotContains(t, config.SeedInstances, "2")
+	require.Empty(t, c.getRemovalCandidates(config))
+
+	e.AssertNotCalled(t, "IsAvailable", "")
+}
+
+func TestController_NewClusterSeeds(t *testing.T) {
+	run := func(id string, instances map[string]aws.Instance) ([]string, error) {
+		a := &MockAWS{}
+		e := &MockETCD{}
+
+		cfg := etcdTestConfig
+		cfg.EnvFile = tempFileName()
+		os.Remove(cfg.EnvFile)
+		defer os.Remove(cfg.EnvFile)
+
+		a.On("InstanceID").Return(id)
+		a.On("IP").Return(id + ".ec2.internal")
+		a.On("GroupName").Return("test")
+		a.On("GroupInstances").Return(instances, nil)
+		e.On("IsAvailable", mock.Anything).Return(false)
+		e.On("Config").Return(cfg)
+
+		c := &Controller{discovery: asgDiscovery(a), aws: a, etcd: e}
+		err := c.Run()
+		if err != nil {
+			_, statErr := os.Stat(cfg.EnvFile)
+			require.True(t, os.IsNotExist(statErr))
+			return nil, err
+		}
+		realized := c.getRealizedConfig(mustRefresh(t, c))
+		return realized.InitialCluster, nil
+	}
+
+	// While the second instance is pending it is not a seed, so it waits
+	// rather than forming a cluster of its own next to the first.
+	booting := map[string]aws.Instance{
+		"1": {IP: "1.ec2.internal", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"2": {IP: "2.ec2.internal", LifecycleState: "Pending", HealthStatus: "Healthy"},
+	}
+	first, err := run("1", booting)
+	require.Nil(t, err)
+	require.Equal(t, []string{"1=https://1.ec2.internal:2379"}, first)
+	_, err = run("2", booting)
+	require.Equal(t, ErrNotSeed, err)
+
+	// Once both are seeds they render the same initial cluster.
+	ready := inService(map[string]string{"1": "1.ec2.internal", "2": "2.ec2.internal"})
+	first, err = run("1", ready)
+	require.Nil(t, err)
+	second, err := run("2", ready)
+	require.Nil(t, err)
+	require.Equal(t, first, second)
+	require.Len(t, first, 2)
+}
+
+func mustRefresh(t *testing.T, c *Controller) *Config {
+	config, err := c.refreshConfig()
+	require.Nil(t, err)
+	return config
 }
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+)
+
+// writeFile atomically replaces the file with data. It reports whether the
+// contents changed so callers can skip work when nothing was updated.
+func writeFile(name string, data []byte, perm os.FileMode) (bool, error) {
+	return writeOwnedFile(name, data, perm, -1, -1)
+}
+
+// writeOwnedFile is writeFile that also sets the owner of the file. A uid or
+// gid of -1 leaves that id unchanged.
+func writeOwnedFile(name string, data []byte, perm os.FileMode, uid, gid int) (bool, error) {
+	current, err := ioutil.ReadFile(name)
+	if err == nil && bytes.Equal(current, data) {
+		err = os.Chmod(name, perm)
+		if err == nil && (uid != -1 || gid != -1) {
+			err = os.Chown(name, uid, gid)
+		}
+		return false, err
+	}
+
+	dir := filepath.Dir(name)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return false, err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+"-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if err == nil && (uid != -1 || gid != -1) {
+		err = f.Chown(uid, gid)
+	}
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, os.Rename(f.Name(), name)
+}
+
+// parseFileMode parses an octal file mode, an empty value gives the default.
+func parseFileMode(mode string, defaults os.FileMode) (os.FileMode, error) {
+	if mode == "" {
+		return defaults, nil
+	}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(m), nil
+}
+
+// parseOwner parses a uid or gid, an empty value means the id is unchanged.
+func parseOwner(id string) (int, error) {
+	if id == "" {
+		return -1, nil
+	}
+	return strconv.Atoi(id)
+}
+
+// fileOwner parses the configured owner of every file written for etcd.
+func fileOwner(cfg etcd.Config) (uid, gid int, err error) {
+	uid, err = parseOwner(cfg.EnvFileUID)
+	if err != nil {
+		return -1, -1, err
+	}
+	gid, err = parseOwner(cfg.EnvFileGID)
+	if err != nil {
+		return -1, -1, err
+	}
+	return uid, gid, nil
+}
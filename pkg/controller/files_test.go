@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "files-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "config")
+
+	changed, err := writeFile(name, []byte("a"), 0600)
+	require.Nil(t, err)
+	require.True(t, changed)
+
+	changed, err = writeFile(name, []byte("a"), 0600)
+	require.Nil(t, err)
+	require.False(t, changed)
+
+	changed, err = writeFile(name, []byte("b"), 0640)
+	require.Nil(t, err)
+	require.True(t, changed)
+
+	info, err := os.Stat(name)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestParseFileOptions(t *testing.T) {
+	mode, err := parseFileMode("", 0700)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0700), mode)
+
+	mode, err = parseFileMode("0640", 0700)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0640), mode)
+
+	_, err = parseFileMode("rw-r--r--", 0700)
+	require.NotNil(t, err)
+
+	id, err := parseOwner("")
+	require.Nil(t, err)
+	require.Equal(t, -1, id)
+
+	id, err = parseOwner("232")
+	require.Nil(t, err)
+	require.Equal(t, 232, id)
+
+	_, err = parseOwner("etcd")
+	require.NotNil(t, err)
+}
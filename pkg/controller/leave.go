@@ -0,0 +1,196 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+)
+
+// leftMarker is written to the env file once this member has left the
+// cluster. Its data dir is stale from then on, so runs refuse to rejoin
+// until the data dir is cleared and the env file removed.
+const leftMarker = "ETCD_AWS_CLUSTER_LEFT"
+
+// Leave removes this member from the cluster ahead of its instance going
+// away. Its target is drained first so clients are not sent to a removed
+// member, then leadership is moved to another member so the removal does not
+// wait out an election, and the env file is marked so the node does not
+// rejoin with its stale data.
+func (c *Controller) Leave(reason string) error {
+	cfg := c.etcd.Config()
+	left, err := hasLeft(cfg.EnvFile)
+	if err != nil {
+		return err
+	}
+	if left {
+		log.Printf("already left the cluster")
+		return nil
+	}
+
+	config, err := c.refreshConfig()
+	if err != nil {
+		return err
+	}
+	log.Printf("leaving the cluster: %s", reason)
+
+	self := config.InstanceID
+	err = c.deregisterTargets(config, []string{self})
+	if err != nil {
+		return err
+	}
+
+	_, member := config.ActiveMembers[self]
+	host := leaveHost(config)
+	switch {
+	case !member || host == "":
+		log.Printf("not a member of an available cluster")
+	case len(config.ActiveMembers) == 1:
+		log.Printf("not removing the last member of the cluster")
+	default:
+		err = c.etcd.MoveLeader(host, self)
+		if err != nil {
+			// Removing the leader still works, it only costs an election.
+			log.Printf("failed to move leadership: %v", err)
+		}
+		log.Printf("removing self from cluster: %s", self)
+		err = c.etcd.Remove(host, self)
+		if err != nil {
+			return err
+		}
+	}
+
+	return markLeft(cfg, reason)
+}
+
+// departingStates are the lifecycle states of an instance that is leaving
+// its autoscaling group for good.
+var departingStates = []string{
+	"Terminating",
+	"Terminating:Wait",
+	"Terminating:Proceed",
+	"Detaching",
+	"Detached",
+}
+
+// LeaveIfDeparting leaves the cluster when the autoscaling lifecycle state
+// shows that this instance is going away, such as on scale-in or from a
+// lifecycle hook. On a plain reboot or service stop the member is kept.
+func (c *Controller) LeaveIfDeparting() error {
+	if c.aws == nil {
+		return errors.New("controller: the lifecycle state needs the aws client, leave with -force instead")
+	}
+	state, err := c.aws.LifecycleState()
+	if err != nil {
+		return err
+	}
+	for _, s := range departingStates {
+		if s == state {
+			return c.Leave("instance is " + state)
+		}
+	}
+	log.Printf("instance is %s, keeping membership", state)
+	return nil
+}
+
+// leaveHost returns an available member to send the removal to, another
+// member is preferred as this one may stop serving at any time.
+func leaveHost(config *Config) string {
+	var ids []string
+	for id := range config.AvailableMembers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if config.AvailableMembers[id] && id != config.InstanceID && config.Instances[id] != "" {
+			return config.Instances[id]
+		}
+	}
+	if config.AvailableMembers[config.InstanceID] {
+		return config.InstanceHost
+	}
+	return ""
+}
+
+func hasLeft(envFile string) (bool, error) {
+	data, err := ioutil.ReadFile(envFile)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), leftMarker+"=") {
+			return true, nil
+		}
+	}
+	return false, s.Err()
+}
+
+// markLeft appends the left marker to the env file, keeping its contents.
+func markLeft(cfg etcd.Config, reason string) error {
+	current, err := ioutil.ReadFile(cfg.EnvFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	uid, err := parseOwner(cfg.EnvFileUID)
+	if err != nil {
+		return err
+	}
+	gid, err := parseOwner(cfg.EnvFileGID)
+	if err != nil {
+		return err
+	}
+	mode, err := parseFileMode(cfg.EnvFileMode, 0700)
+	if err != nil {
+		return err
+	}
+	marker := fmt.Sprintf("%s=%q\n", leftMarker, reason)
+	log.Printf("marking config as left: %s", cfg.EnvFile)
+	_, err = writeOwnedFile(cfg.EnvFile, append(current, marker...), mode, uid, gid)
+	return err
+}
+
+// checkNotices leaves the cluster when the instance is about to be
+// interrupted. Spot interruptions are acted on right away, maintenance
+// events once they are within the configured window. It reports whether the
+// member has left, after which there is nothing left to check.
+func (c *Controller) checkNotices(leaveBefore time.Duration) bool {
+	left, err := hasLeft(c.etcd.Config().EnvFile)
+	if err != nil {
+		log.Printf("failed to check the env file: %v", err)
+		return false
+	}
+	if left {
+		return true
+	}
+	notices, err := c.aws.Notices()
+	if err != nil {
+		log.Printf("failed to check interruption notices: %v", err)
+		return false
+	}
+	for _, n := range notices {
+		if n.Source == aws.NoticeMaintenance && time.Until(n.Time) > leaveBefore {
+			continue
+		}
+		reason := fmt.Sprintf("%s %s at %s", n.Source, n.Action, n.Time.Format(time.RFC3339))
+		err = c.Leave(reason)
+		if err != nil {
+			log.Printf("failed to leave the cluster: %v", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// leaveTest sets up a three member cluster with this instance as member 1.
+func leaveTest(t *testing.T) (*Controller, *MockAWS, *MockETCD, etcd.Config, func()) {
+	dir, err := ioutil.TempDir("", "leave")
+	require.Nil(t, err)
+
+	cfg := etcdTestConfig
+	cfg.EnvFile = filepath.Join(dir, "config")
+	require.Nil(t, ioutil.WriteFile(cfg.EnvFile, []byte("ETCD_NAME=\"1\"\n"), 0600))
+
+	a := &MockAWS{}
+	a.On("InstanceID").Return("1")
+	a.On("IP").Return("1.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(inService(map[string]string{
+		"1": "1.ec2.internal",
+		"2": "2.ec2.internal",
+		"3": "3.ec2.internal",
+	}), nil)
+
+	e := &MockETCD{}
+	e.On("Config").Return(cfg)
+	members := map[string]string{"1": "1.ec2.internal", "2": "2.ec2.internal", "3": "3.ec2.internal"}
+	for _, host := range members {
+		e.On("IsAvailable", host).Return(true)
+		e.On("Members", host).Return(members, nil)
+	}
+
+	c := &Controller{discovery: asgDiscovery(a), aws: a, etcd: e}
+	return c, a, e, cfg, func() { os.RemoveAll(filepath.Dir(cfg.EnvFile)) }
+}
+
+func TestController_Leave(t *testing.T) {
+	c, _, e, cfg, cleanup := leaveTest(t)
+	defer cleanup()
+
+	e.On("MoveLeader", "2.ec2.internal", "1").Return(etcd.ErrMoveLeaderUnsupported).Once()
+	e.On("Remove", "2.ec2.internal", "1").Return(nil).Once()
+
+	require.Nil(t, c.Leave("spot terminate"))
+
+	data, err := ioutil.ReadFile(cfg.EnvFile)
+	require.Nil(t, err)
+	require.Equal(t, "ETCD_NAME=\"1\"\nETCD_AWS_CLUSTER_LEFT=\"spot terminate\"\n", string(data))
+
+	// Leaving again is a no-op and runs refuse to rejoin.
+	require.Nil(t, c.Leave("spot terminate"))
+	err = c.Run()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "left the cluster")
+	e.AssertExpectations(t)
+}
+
+func TestController_LeaveDrainsFirst(t *testing.T) {
+	c, a, e, cfg, cleanup := leaveTest(t)
+	defer cleanup()
+
+	cfg.TargetGroupARN = "arn"
+	cfg.TargetGroupDrainTimeout = "1m"
+	e.ExpectedCalls = nil
+	e.On("Config").Return(cfg)
+	members := map[string]string{"1": "1.ec2.internal", "2": "2.ec2.internal", "3": "3.ec2.internal"}
+	for _, host := range members {
+		e.On("IsAvailable", host).Return(true)
+		e.On("Members", host).Return(members, nil)
+	}
+
+	group := &fakeTargetGroup{targets: map[string]string{"1": "healthy", "2": "healthy"}}
+	a.On("TargetGroup", "arn").Return(group)
+
+	// The target is drained before the member is touched.
+	drained := func(mock.Arguments) { require.Equal(t, []string{"1"}, group.drained) }
+	e.On("MoveLeader", "2.ec2.internal", "1").Return(nil).Run(drained).Once()
+	e.On("Remove", "2.ec2.internal", "1").Return(nil).Run(drained).Once()
+
+	require.Nil(t, c.Leave("test"))
+	require.Equal(t, []string{"1"}, group.drained)
+	e.AssertExpectations(t)
+}
+
+func TestController_LeaveRemoveFails(t *testing.T) {
+	c, _, e, cfg, cleanup := leaveTest(t)
+	defer cleanup()
+
+	e.On("MoveLeader", "2.ec2.internal", "1").Return(nil)
+	e.On("Remove", "2.ec2.internal", "1").Return(errors.New("failed"))
+
+	require.NotNil(t, c.Leave("test"))
+	left, err := hasLeft(cfg.EnvFile)
+	require.Nil(t, err)
+	require.False(t, left)
+}
+
+func TestController_CheckNotices(t *testing.T) {
+	c, a, e, cfg, cleanup := leaveTest(t)
+	defer cleanup()
+
+	// A maintenance event outside of the window is left alone.
+	a.On("Notices").Return([]aws.Notice{
+		{Source: aws.NoticeMaintenance, Action: "system-reboot", Time: time.Now().Add(24 * time.Hour)},
+	}, nil).Once()
+	require.False(t, c.checkNotices(15*time.Minute))
+	left, _ := hasLeft(cfg.EnvFile)
+	require.False(t, left)
+
+	a.On("Notices").Return([]aws.Notice{
+		{Source: aws.NoticeSpot, Action: "terminate", Time: time.Now().Add(2 * time.Minute)},
+	}, nil).Once()
+	e.On("MoveLeader", "2.ec2.internal", "1").Return(nil).Once()
+	e.On("Remove", "2.ec2.internal", "1").Return(nil).Once()
+	require.True(t, c.checkNotices(15*time.Minute))
+	left, _ = hasLeft(cfg.EnvFile)
+	require.True(t, left)
+	e.AssertExpectations(t)
+
+	// Once left, notices are no longer fetched.
+	require.True(t, c.checkNotices(15*time.Minute))
+	a.AssertNumberOfCalls(t, "Notices", 2)
+}
+
+func TestLeaveHost(t *testing.T) {
+	config := &Config{
+		InstanceID:       "1",
+		InstanceHost:     "1.ec2.internal",
+		Instances:        map[string]string{"1": "1.ec2.internal", "2": "2.ec2.internal"},
+		AvailableMembers: map[string]bool{"1": true, "2": false},
+	}
+	require.Equal(t, "1.ec2.internal", leaveHost(config))
+
+	config.AvailableMembers["2"] = true
+	require.Equal(t, "2.ec2.internal", leaveHost(config))
+
+	config.AvailableMembers = map[string]bool{}
+	require.Equal(t, "", leaveHost(config))
+}
+
+func TestController_LeaveIfDeparting(t *testing.T) {
+	c, a, e, cfg, cleanup := leaveTest(t)
+	defer cleanup()
+
+	a.On("LifecycleState").Return("InService", nil).Once()
+	require.Nil(t, c.LeaveIfDeparting())
+	left, _ := hasLeft(cfg.EnvFile)
+	require.False(t, left)
+
+	a.On("LifecycleState").Return("Terminating:Wait", nil).Once()
+	e.On("MoveLeader", "2.ec2.internal", "1").Return(nil).Once()
+	e.On("Remove", "2.ec2.internal", "1").Return(nil).Once()
+	require.Nil(t, c.LeaveIfDeparting())
+	left, _ = hasLeft(cfg.EnvFile)
+	require.True(t, left)
+	e.AssertExpectations(t)
+
+	require.NotNil(t, (&Controller{etcd: e}).LeaveIfDeparting())
+}
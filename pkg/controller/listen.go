@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Host values resolved from the addresses of this instance.
+const (
+	hostPrivateIP  = "private-ip"
+	hostPrivateDNS = "private-dns"
+	hostRecord     = "record"
+)
+
+// wildcardHost is listened on unless a listen address is set.
+const wildcardHost = "0.0.0.0"
+
+// resolveHosts fills in the listen host and the extra advertised client
+// hosts of this instance from the configured values.
+func (c *Controller) resolveHosts(config *Config, ip string) error {
+	listen := config.ListenAddress
+	if listen == hostPrivateIP {
+		if ip == "" {
+			return errors.New("controller: listen address private-ip but the instance has no private ip")
+		}
+		listen = ip
+	}
+	config.ListenHost = listen
+
+	config.ExtraClientHosts = nil
+	for _, value := range config.AdvertiseClientHosts {
+		host := value
+		switch value {
+		case hostPrivateIP:
+			host = ip
+		case hostPrivateDNS:
+			if c.aws == nil {
+				return fmt.Errorf("controller: advertise host %s needs the aws client", value)
+			}
+			host = c.aws.Hostname()
+		case hostRecord:
+			domain := recordDomain(config.Config)
+			if domain == "" {
+				return fmt.Errorf("controller: advertise host %s needs a route53 domain", value)
+			}
+			host = memberRecordName(domain, config.InstanceID, config.InstanceHost)
+		}
+		if host == "" {
+			return fmt.Errorf("controller: advertise host %s is not known for this instance", value)
+		}
+		config.ExtraClientHosts = append(config.ExtraClientHosts, host)
+	}
+	return nil
+}
+
+// listenURLs returns the listen urls of a role on the listen host. The
+// loopback address is added when asked for, unless the wildcard address
+// already covers it, as both would bind the same port.
+func listenURLs(config *Config, url func(string) string, localhost bool) string {
+	host := config.ListenHost
+	if host == "" {
+		host = wildcardHost
+	}
+	urls := []string{url(host)}
+	if localhost && !isWildcard(host) && host != "127.0.0.1" {
+		urls = append(urls, url("127.0.0.1"))
+	}
+	return strings.Join(urls, ",")
+}
+
+// advertiseClientURLs returns the client url of the instance host followed
+// by those of the extra hosts, without duplicates.
+func advertiseClientURLs(config *Config) string {
+	seen := map[string]bool{}
+	var urls []string
+	for _, host := range append([]string{config.InstanceHost}, config.ExtraClientHosts...) {
+		u := config.ClientURL(host)
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return strings.Join(urls, ",")
+}
+
+// metricsURL returns the plain http url of a metrics port on a host.
+func metricsURL(port string) func(string) string {
+	return func(host string) string {
+		return "http://" + net.JoinHostPort(host, port)
+	}
+}
+
+func isWildcard(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
+
+// listenLocalhost parses whether the loopback address is listened on too.
+func listenLocalhost(value string) bool {
+	localhost, _ := strconv.ParseBool(value)
+	return localhost
+}
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func listenConfig() *Config {
+	cfg := &Config{
+		InstanceID:   "i-1",
+		InstanceHost: "10.0.0.1",
+	}
+	cfg.ClientScheme = "https"
+	cfg.ClientPort = "2379"
+	cfg.PeerScheme = "https"
+	cfg.PeerPort = "2380"
+	return cfg
+}
+
+func TestController_ResolveHosts(t *testing.T) {
+	a := &MockAWS{}
+	a.On("Hostname").Return("ip-10-0-0-1.ec2.internal")
+	c := &Controller{aws: a}
+
+	cfg := listenConfig()
+	cfg.ListenAddress = "private-ip"
+	cfg.Route53Domain = "etcd.internal"
+	cfg.AdvertiseClientHosts = []string{"private-ip", "private-dns", "record", "etcd.example.com"}
+	require.Nil(t, c.resolveHosts(cfg, "10.0.0.1"))
+	require.Equal(t, "10.0.0.1", cfg.ListenHost)
+	require.Equal(t, []string{"10.0.0.1", "ip-10-0-0-1.ec2.internal", "i-1.etcd.internal", "etcd.example.com"}, cfg.ExtraClientHosts)
+
+	require.NotNil(t, c.resolveHosts(cfg, ""))
+
+	cfg = listenConfig()
+	cfg.AdvertiseClientHosts = []string{"record"}
+	require.NotNil(t, c.resolveHosts(cfg, "10.0.0.1"))
+
+	cfg.AdvertiseClientHosts = []string{"private-dns"}
+	require.NotNil(t, (&Controller{}).resolveHosts(cfg, "10.0.0.1"))
+}
+
+func TestController_RealizedListen(t *testing.T) {
+	cfg := listenConfig()
+	cfg.ListenHost = "10.0.0.1"
+	cfg.ListenLocalhost = "true"
+	cfg.MetricsPort = "2381"
+	cfg.ExtraClientHosts = []string{"10.0.0.1", "etcd.example.com"}
+
+	realized := (&Controller{}).getRealizedConfig(cfg)
+	require.Equal(t, "https://10.0.0.1:2379,https://127.0.0.1:2379", realized.ListenClientURL)
+	require.Equal(t, "https://10.0.0.1:2380", realized.ListenPeerURL)
+	require.Equal(t, "http://10.0.0.1:2381,http://127.0.0.1:2381", realized.ListenMetricsURL)
+	require.Equal(t, "https://10.0.0.1:2379,https://etcd.example.com:2379", realized.InitialAdvertiseClientURL)
+
+	out, err := renderers["env"].Render(realized)
+	require.Nil(t, err)
+	require.Contains(t, string(out), `ETCD_LISTEN_PEER_URLS="https://10.0.0.1:2380"
+ETCD_LISTEN_METRICS_URLS="http://10.0.0.1:2381,http://127.0.0.1:2381"
+`)
+	out, err = renderers["flags"].Render(realized)
+	require.Nil(t, err)
+	require.Contains(t, string(out), "--listen-metrics-urls=http://10.0.0.1:2381,http://127.0.0.1:2381\n")
+}
+
+func TestController_RealizedListenWildcard(t *testing.T) {
+	cfg := listenConfig()
+	cfg.ListenLocalhost = "true"
+
+	realized := (&Controller{}).getRealizedConfig(cfg)
+	require.Equal(t, "https://0.0.0.0:2379", realized.ListenClientURL)
+	require.Equal(t, "", realized.ListenMetricsURL)
+
+	out, err := renderers["yaml"].Render(realized)
+	require.Nil(t, err)
+	require.NotContains(t, string(out), "listen-metrics-urls")
+}
@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// defaultPodDataDir is the host data dir of the static pod when no data dir
+// is configured, etcd's own default is relative to its working directory.
+const defaultPodDataDir = "/var/lib/etcd"
+
+// The subset of the kubernetes pod spec used by the static pod manifest.
+type staticPod struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   podMetadata `yaml:"metadata"`
+	Spec       podSpec     `yaml:"spec"`
+}
+
+type podMetadata struct {
+	Name        string            `yaml:"name"`
+	Namespace   string            `yaml:"namespace"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+type podSpec struct {
+	HostNetwork       bool           `yaml:"hostNetwork"`
+	PriorityClassName string         `yaml:"priorityClassName"`
+	Containers        []podContainer `yaml:"containers"`
+	Volumes           []podVolume    `yaml:"volumes"`
+}
+
+type podContainer struct {
+	Name          string           `yaml:"name"`
+	Image         string           `yaml:"image"`
+	Command       []string         `yaml:"command"`
+	Env           []podEnv         `yaml:"env"`
+	LivenessProbe podProbe         `yaml:"livenessProbe"`
+	VolumeMounts  []podVolumeMount `yaml:"volumeMounts"`
+}
+
+type podEnv struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type podProbe struct {
+	Exec                podExec `yaml:"exec"`
+	InitialDelaySeconds int     `yaml:"initialDelaySeconds"`
+	TimeoutSeconds      int     `yaml:"timeoutSeconds"`
+	FailureThreshold    int     `yaml:"failureThreshold"`
+}
+
+type podExec struct {
+	Command []string `yaml:"command"`
+}
+
+type podVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+}
+
+type podVolume struct {
+	Name     string `yaml:"name"`
+	HostPath struct {
+		Path string `yaml:"path"`
+		Type string `yaml:"type"`
+	} `yaml:"hostPath"`
+}
+
+// staticPodRenderer renders a kubeadm style static pod manifest for the
+// kubelet manifest directory. The kubelet ignores hidden files, so the
+// temporary file of the atomic write is never picked up as a pod.
+type staticPodRenderer struct{}
+
+func (staticPodRenderer) Render(r *RealizedConfig) ([]byte, error) {
+	realized := *r
+	if realized.Tuning.DataDir == "" {
+		realized.Tuning.DataDir = defaultPodDataDir
+	}
+	flags, err := etcdFlags(&realized)
+	if err != nil {
+		return nil, err
+	}
+	err = checkImageFlags(realized.StaticPodImage, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	container := podContainer{
+		Name:          "etcd",
+		Image:         realized.StaticPodImage,
+		Command:       append([]string{"etcd"}, flags...),
+		Env:           []podEnv{{Name: "ETCDCTL_API", Value: "3"}},
+		LivenessProbe: livenessProbe(&realized),
+	}
+	pod := staticPod{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Metadata: podMetadata{
+			Name:      "etcd",
+			Namespace: "kube-system",
+			Labels: map[string]string{
+				"component": "etcd",
+				"tier":      "control-plane",
+			},
+			Annotations: map[string]string{
+				"scheduler.alpha.kubernetes.io/critical-pod": "",
+			},
+		},
+		Spec: podSpec{
+			HostNetwork:       true,
+			PriorityClassName: "system-cluster-critical",
+		},
+	}
+
+	// Directories are mounted rather than files so that certificates which
+	// are renewed by replacing the file are seen by the running pod.
+	dirs := []string{realized.Tuning.DataDir}
+	for _, dir := range certificateDirs(&realized) {
+		if dir != realized.Tuning.DataDir {
+			dirs = append(dirs, dir)
+		}
+	}
+	for i, dir := range dirs {
+		name := "etcd-data"
+		if i > 0 {
+			name = fmt.Sprintf("etcd-certs-%d", i-1)
+		}
+		volume := podVolume{Name: name}
+		volume.HostPath.Path = dir
+		volume.HostPath.Type = "DirectoryOrCreate"
+		pod.Spec.Volumes = append(pod.Spec.Volumes, volume)
+		container.VolumeMounts = append(container.VolumeMounts, podVolumeMount{Name: name, MountPath: dir})
+	}
+	pod.Spec.Containers = []podContainer{container}
+	return yaml.Marshal(pod)
+}
+
+// flagVersions are the etcd versions that added the flags etcdFlags renders
+// which older versions reject on startup.
+var flagVersions = map[string][2]int{
+	"listen-metrics-urls":  {3, 3},
+	"auto-compaction-mode": {3, 3},
+}
+
+// checkImageFlags fails when the etcd version in the image tag is known to
+// reject one of the flags, which would leave the pod crash looping. Images
+// without a version tag are not checked.
+func checkImageFlags(image string, flags []string) error {
+	major, minor, ok := imageVersion(image)
+	if !ok {
+		return nil
+	}
+	for _, flag := range flags {
+		name := strings.SplitN(strings.TrimPrefix(flag, "--"), "=", 2)[0]
+		v, ok := flagVersions[name]
+		if ok && (major < v[0] || (major == v[0] && minor < v[1])) {
+			return fmt.Errorf("controller: --%s needs etcd %d.%d or later, the static pod image is %s", name, v[0], v[1], image)
+		}
+	}
+	return nil
+}
+
+// imageVersion parses the major and minor version from the tag of an image
+// such as quay.io/coreos/etcd:v3.3.27.
+func imageVersion(image string) (major, minor int, ok bool) {
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return 0, 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(image[i+1:], "v"), ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// livenessProbe checks the local member with etcdctl on its listen client
+// url, using the client certificates when the client scheme is https.
+func livenessProbe(r *RealizedConfig) podProbe {
+	command := []string{"etcdctl", "--endpoints=" + localURL(r.ListenClientURL)}
+	if r.ClientScheme == "https" {
+		command = append(command,
+			"--cacert="+r.ClientCAFile,
+			"--cert="+r.ClientCertFile,
+			"--key="+r.ClientKeyFile,
+		)
+	}
+	probe := podProbe{
+		InitialDelaySeconds: 15,
+		TimeoutSeconds:      15,
+		FailureThreshold:    8,
+	}
+	probe.Exec.Command = append(command, "endpoint", "health")
+	return probe
+}
+
+// localURL returns the first of the listen urls, dialing the wildcard
+// address on the loopback interface.
+func localURL(listen string) string {
+	first := strings.Split(listen, ",")[0]
+	u, err := url.Parse(first)
+	if err != nil {
+		return first
+	}
+	if host, port, err := net.SplitHostPort(u.Host); err == nil && (host == "0.0.0.0" || host == "::" || host == "") {
+		u.Host = net.JoinHostPort("127.0.0.1", port)
+	}
+	return u.String()
+}
+
+// certificateDirs returns the sorted directories of the certificate files in
+// use by etcd.
+func certificateDirs(r *RealizedConfig) (dirs []string) {
+	seen := map[string]bool{}
+	for _, file := range certificateFiles(r.Config) {
+		dir := filepath.Dir(file)
+		if file != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
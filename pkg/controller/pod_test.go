@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRender_StaticPod(t *testing.T) {
+	r := testRealized()
+	r.StaticPodImage = "quay.io/coreos/etcd:v3.3.27"
+	r.PeerScheme = "https"
+	r.PeerCAFile = "/etc/etcd/peer/ca.pem"
+	r.PeerCertFile = "/etc/etcd/peer/etcd.pem"
+	r.PeerKeyFile = "/etc/etcd/peer/etcd-key.pem"
+
+	out, err := renderers["static-pod"].Render(r)
+	require.Nil(t, err)
+	require.Equal(t, `apiVersion: v1
+kind: Pod
+metadata:
+  name: etcd
+  namespace: kube-system
+  labels:
+    component: etcd
+    tier: control-plane
+  annotations:
+    scheduler.alpha.kubernetes.io/critical-pod: ""
+spec:
+  hostNetwork: true
+  priorityClassName: system-cluster-critical
+  containers:
+  - name: etcd
+    image: quay.io/coreos/etcd:v3.3.27
+    command:
+    - etcd
+    - --name=1
+    - --initial-cluster-state=new
+    - --initial-cluster=1=https://1.ec2.internal:2380,2=https://2.ec2.internal:2380
+    - --listen-client-urls=https://0.0.0.0:2379
+    - --listen-peer-urls=https://0.0.0.0:2380
+    - --initial-advertise-peer-urls=https://1.ec2.internal:2380
+    - --advertise-client-urls=https://1.ec2.internal:2379
+    - --data-dir=/var/lib/etcd
+    - --trusted-ca-file=/etc/etcd/certs/ca.pem
+    - --cert-file=/etc/etcd/certs/etcd.pem
+    - --key-file=/etc/etcd/certs/etcd-key.pem
+    - --client-cert-auth=true
+    - --peer-trusted-ca-file=/etc/etcd/peer/ca.pem
+    - --peer-cert-file=/etc/etcd/peer/etcd.pem
+    - --peer-key-file=/etc/etcd/peer/etcd-key.pem
+    - --peer-client-cert-auth=true
+    env:
+    - name: ETCDCTL_API
+      value: "3"
+    livenessProbe:
+      exec:
+        command:
+        - etcdctl
+        - --endpoints=https://127.0.0.1:2379
+        - --cacert=/etc/etcd/certs/ca.pem
+        - --cert=/etc/etcd/certs/etcd.pem
+        - --key=/etc/etcd/certs/etcd-key.pem
+        - endpoint
+        - health
+      initialDelaySeconds: 15
+      timeoutSeconds: 15
+      failureThreshold: 8
+    volumeMounts:
+    - name: etcd-data
+      mountPath: /var/lib/etcd
+    - name: etcd-certs-0
+      mountPath: /etc/etcd/certs
+    - name: etcd-certs-1
+      mountPath: /etc/etcd/peer
+  volumes:
+  - name: etcd-data
+    hostPath:
+      path: /var/lib/etcd
+      type: DirectoryOrCreate
+  - name: etcd-certs-0
+    hostPath:
+      path: /etc/etcd/certs
+      type: DirectoryOrCreate
+  - name: etcd-certs-1
+    hostPath:
+      path: /etc/etcd/peer
+      type: DirectoryOrCreate
+`, string(out))
+}
+
+func TestRender_StaticPodHTTP(t *testing.T) {
+	r := testRealized()
+	r.ClientScheme = "http"
+	r.ListenClientURL = "http://0.0.0.0:2379"
+	r.Tuning.DataDir = "/data/etcd"
+
+	out, err := renderers["static-pod"].Render(r)
+	require.Nil(t, err)
+	require.Contains(t, string(out), "    - --data-dir=/data/etcd\n")
+	require.Contains(t, string(out), "        - etcdctl\n        - --endpoints=http://127.0.0.1:2379\n        - endpoint\n")
+	require.Contains(t, string(out), "  volumes:\n  - name: etcd-data\n    hostPath:\n      path: /data/etcd\n      type: DirectoryOrCreate\n")
+	require.NotContains(t, string(out), "etcd-certs")
+}
+
+func TestRender_StaticPodImageVersion(t *testing.T) {
+	r := testRealized()
+	r.StaticPodImage = "quay.io/coreos/etcd:v3.2.18"
+	r.ListenMetricsURL = "http://0.0.0.0:2381"
+	_, err := renderers["static-pod"].Render(r)
+	require.EqualError(t, err, "controller: --listen-metrics-urls needs etcd 3.3 or later, the static pod image is quay.io/coreos/etcd:v3.2.18")
+
+	// Newer or unversioned images are not rejected.
+	for _, image := range []string{"quay.io/coreos/etcd:v3.3.27", "k8s.gcr.io/etcd:3.4.13-0", "registry:5000/etcd", "etcd@sha256:abc"} {
+		r.StaticPodImage = image
+		_, err = renderers["static-pod"].Render(r)
+		require.Nil(t, err, image)
+	}
+}
+
+func TestLocalURL(t *testing.T) {
+	require.Equal(t, "https://127.0.0.1:2379", localURL("https://0.0.0.0:2379"))
+	require.Equal(t, "http://127.0.0.1:2379", localURL("http://[::]:2379,http://10.0.0.1:2379"))
+	require.Equal(t, "https://10.0.0.1:2379", localURL("https://10.0.0.1:2379"))
+}
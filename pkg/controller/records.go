@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+)
+
+func recordDomain(cfg etcd.Config) string {
+	return strings.TrimSuffix(strings.ToLower(cfg.Route53Domain), ".")
+}
+
+// srvRecordName is the name etcd looks up for the peer ("server") or client
+// SRV records of a domain.
+func srvRecordName(kind, scheme, domain string) string {
+	name := "_etcd-" + kind
+	if scheme == "https" {
+		name += "-ssl"
+	}
+	return name + "._tcp." + domain
+}
+
+// memberRecordName is the A record name of an instance. Hosts that already
+// are names within the domain, as with the dns advertise mode, are used as
+// they are.
+func memberRecordName(domain, id, host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) == nil && strings.HasSuffix(host, "."+domain) {
+		return host
+	}
+	return strings.ToLower(id) + "." + domain
+}
+
+// desiredRecords returns an A record for every instance that keeps its
+// membership and the peer and client SRV records pointing at them. Proxies
+// serve clients but are left out of the peer records.
+func desiredRecords(config *Config, ttl int64) []aws.Record {
+	domain := recordDomain(config.Config)
+
+	var records []aws.Record
+	var peers, clients []string
+	for id, host := range config.Instances {
+		ip := config.InstanceIPs[id]
+		if ip == "" {
+			continue
+		}
+		name := memberRecordName(domain, id, host)
+		records = append(records, aws.Record{Name: name, Type: "A", TTL: ttl, Values: []string{ip}})
+		if config.IsVoter(id) {
+			peers = append(peers, "0 0 "+config.PeerPort+" "+name)
+		}
+		clients = append(clients, "0 0 "+config.ClientPort+" "+name)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	if len(peers) == 0 {
+		return records
+	}
+	sort.Strings(peers)
+	sort.Strings(clients)
+	return append(records,
+		aws.Record{Name: srvRecordName("server", config.PeerScheme, domain), Type: "SRV", TTL: ttl, Values: peers},
+		aws.Record{Name: srvRecordName("client", config.ClientScheme, domain), Type: "SRV", TTL: ttl, Values: clients},
+	)
+}
+
+// managedRecord reports whether the record is owned by the controller. These
+// are the A records below the domain and the etcd SRV records of the domain,
+// so the domain should be dedicated to the cluster.
+func managedRecord(domain string, r aws.Record) bool {
+	name := strings.ToLower(r.Name)
+	switch r.Type {
+	case "A":
+		return strings.HasSuffix(name, "."+domain)
+	case "SRV":
+		for _, kind := range []string{"server", "client"} {
+			for _, scheme := range []string{"http", "https"} {
+				if name == srvRecordName(kind, scheme, domain) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func diffRecords(domain string, current, desired []aws.Record) (upserts, deletes []aws.Record) {
+	want := map[string]aws.Record{}
+	for _, r := range desired {
+		want[r.Type+" "+r.Name] = r
+	}
+	have := map[string]aws.Record{}
+	for _, r := range current {
+		if !managedRecord(domain, r) {
+			continue
+		}
+		key := r.Type + " " + strings.ToLower(r.Name)
+		have[key] = r
+		if _, ok := want[key]; !ok {
+			deletes = append(deletes, r)
+		}
+	}
+	for _, r := range desired {
+		cur, ok := have[r.Type+" "+r.Name]
+		if ok {
+			cur.Name = strings.ToLower(cur.Name)
+			sort.Strings(cur.Values)
+		}
+		if !ok || !reflect.DeepEqual(cur, r) {
+			upserts = append(upserts, r)
+		}
+	}
+	return upserts, deletes
+}
+
+// syncRecords keeps the member and SRV records in the Route53 zone in line
+// with the instances, records of removed members are deleted.
+func (c *Controller) syncRecords(config *Config) error {
+	if config.Route53ZoneID == "" {
+		return nil
+	}
+	domain := recordDomain(config.Config)
+	if domain == "" {
+		return errors.New("controller: a route53 domain is required with a route53 zone")
+	}
+	ttl, err := strconv.ParseInt(config.Route53TTL, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	zone := c.aws.Zone(config.Route53ZoneID)
+	current, err := zone.Records()
+	if err != nil {
+		return err
+	}
+	upserts, deletes := diffRecords(domain, current, desiredRecords(config, ttl))
+	for _, r := range deletes {
+		log.Printf("deleting record: %s %s", r.Type, r.Name)
+	}
+	for _, r := range upserts {
+		log.Printf("updating record: %s %s %v", r.Type, r.Name, r.Values)
+	}
+	return zone.Change(upserts, deletes)
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeZone is an in-memory Route53 zone. Deletes must match the existing
+// record exactly, as they do in Route53.
+type fakeZone struct {
+	records map[string]aws.Record
+	changes int
+}
+
+func newFakeZone(records ...aws.Record) *fakeZone {
+	z := &fakeZone{records: map[string]aws.Record{}}
+	for _, r := range records {
+		z.records[r.Type+" "+r.Name] = r
+	}
+	return z
+}
+
+func (z *fakeZone) Records() ([]aws.Record, error) {
+	var out []aws.Record
+	for _, r := range z.records {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (z *fakeZone) Change(upserts, deletes []aws.Record) error {
+	if len(upserts)+len(deletes) == 0 {
+		return nil
+	}
+	z.changes++
+	for _, r := range deletes {
+		key := r.Type + " " + r.Name
+		if !reflect.DeepEqual(z.records[key], r) {
+			return errors.New("record not found: " + key)
+		}
+		delete(z.records, key)
+	}
+	for _, r := range upserts {
+		z.records[r.Type+" "+r.Name] = r
+	}
+	return nil
+}
+
+func TestController_SyncRecords(t *testing.T) {
+	zone := newFakeZone(
+		aws.Record{Name: "etcd.internal", Type: "SOA", TTL: 900, Values: []string{"soa"}},
+		aws.Record{Name: "i-1.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.9"}},
+		aws.Record{Name: "i-3.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.3"}},
+		aws.Record{Name: "other.example.com", Type: "A", TTL: 60, Values: []string{"10.0.1.1"}},
+	)
+	a := &MockAWS{}
+	a.On("Zone", "Z1").Return(zone)
+
+	cfg := etcdTestConfig
+	cfg.Route53ZoneID = "Z1"
+	cfg.Route53Domain = "etcd.internal."
+	cfg.Route53TTL = "60"
+	config := &Config{
+		Config: cfg,
+		Instances: map[string]string{
+			"i-1": "10.0.0.1",
+			"i-2": "i-2.etcd.internal",
+			"i-4": "",
+		},
+		InstanceIPs: map[string]string{
+			"i-1": "10.0.0.1",
+			"i-2": "10.0.0.2",
+		},
+	}
+
+	c := &Controller{aws: a}
+	require.Nil(t, c.syncRecords(config))
+
+	records, _ := zone.Records()
+	require.Equal(t, []aws.Record{
+		{Name: "_etcd-client-ssl._tcp.etcd.internal", Type: "SRV", TTL: 60, Values: []string{
+			"0 0 2380 i-1.etcd.internal",
+			"0 0 2380 i-2.etcd.internal",
+		}},
+		{Name: "_etcd-server-ssl._tcp.etcd.internal", Type: "SRV", TTL: 60, Values: []string{
+			"0 0 2379 i-1.etcd.internal",
+			"0 0 2379 i-2.etcd.internal",
+		}},
+		{Name: "etcd.internal", Type: "SOA", TTL: 900, Values: []string{"soa"}},
+		{Name: "i-1.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.1"}},
+		{Name: "i-2.etcd.internal", Type: "A", TTL: 60, Values: []string{"10.0.0.2"}},
+		{Name: "other.example.com", Type: "A", TTL: 60, Values: []string{"10.0.1.1"}},
+	}, records)
+
+	// Records in sync are left alone.
+	require.Nil(t, c.syncRecords(config))
+	require.Equal(t, 1, zone.changes)
+}
+
+func TestController_SyncRecordsDisabled(t *testing.T) {
+	c := &Controller{}
+	require.Nil(t, c.syncRecords(&Config{Config: etcdTestConfig}))
+
+	cfg := etcdTestConfig
+	cfg.Route53ZoneID = "Z1"
+	require.NotNil(t, c.syncRecords(&Config{Config: cfg}))
+}
+
+func TestController_SRVBootstrap(t *testing.T) {
+	cfg := etcdTestConfig
+	cfg.Route53ZoneID = "Z1"
+	cfg.Route53Domain = "etcd.internal"
+	cfg.Bootstrap = "srv"
+	config := &Config{
+		Config:        cfg,
+		InstanceID:    "1",
+		InstanceHost:  "1.ec2.internal",
+		SeedInstances: map[string]string{"2": "2.ec2.internal"},
+	}
+
+	realized := (&Controller{}).getRealizedConfig(config)
+	require.Equal(t, "etcd.internal", realized.DiscoverySRV)
+
+	vars := configVars(t, realized)
+	require.Contains(t, vars, `ETCD_DISCOVERY_SRV="etcd.internal"`)
+	require.False(t, strings.Contains(vars, "ETCD_INITIAL_CLUSTER="))
+}
+
+func TestCertificateHosts(t *testing.T) {
+	a := &MockAWS{}
+	a.On("InstanceID").Return("i-1")
+	a.On("IP").Return("10.0.0.1")
+
+	cfg := etcdTestConfig
+	require.Equal(t, []string{"10.0.0.1"}, certificateHosts(cfg, asgDiscovery(a)))
+
+	cfg.Route53ZoneID = "Z1"
+	cfg.Route53Domain = "etcd.internal"
+	require.Equal(t, []string{"10.0.0.1", "i-1.etcd.internal"}, certificateHosts(cfg, asgDiscovery(a)))
+
+	cfg.Route53ZoneID = ""
+	cfg.AdvertiseClientHosts = []string{"private-ip", "private-dns", "record", "etcd.example.com"}
+	require.Equal(t, []string{"10.0.0.1", "i-1.etcd.internal", "etcd.example.com"}, certificateHosts(cfg, asgDiscovery(a)))
+}
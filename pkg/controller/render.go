@@ -0,0 +1,314 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"gopkg.in/yaml.v2"
+)
+
+// Renderer renders the realized config in one output format.
+type Renderer interface {
+	Render(r *RealizedConfig) ([]byte, error)
+}
+
+// renderers are the built-in output formats by name.
+var renderers = map[string]Renderer{
+	"env":        envRenderer{},
+	"yaml":       yamlRenderer{},
+	"json":       jsonRenderer{},
+	"flags":      flagsRenderer{},
+	"static-pod": staticPodRenderer{},
+}
+
+// envRenderer renders a systemd EnvironmentFile with the ETCD_ variables.
+type envRenderer struct{}
+
+var envTemplate = template.Must(template.New("env").Parse(`
+ETCD_INITIAL_CLUSTER_STATE="{{.ClusterState}}"
+ETCD_NAME="{{.Name}}"
+{{with .Proxy}}ETCD_PROXY="{{.}}"
+{{end}}{{if .DiscoverySRV}}ETCD_DISCOVERY_SRV="{{.DiscoverySRV}}"{{else}}ETCD_INITIAL_CLUSTER="{{range $i, $el := .InitialCluster}}{{if $i}},{{end}}{{$el}}{{end}}"{{end}}
+ETCD_LISTEN_CLIENT_URLS="{{.ListenClientURL}}"
+ETCD_LISTEN_PEER_URLS="{{.ListenPeerURL}}"
+{{with .ListenMetricsURL}}ETCD_LISTEN_METRICS_URLS="{{.}}"
+{{end}}ETCD_INITIAL_ADVERTISE_PEER_URLS="{{.InitialAdvertisePeerURL}}"
+ETCD_ADVERTISE_CLIENT_URLS="{{.InitialAdvertiseClientURL}}"
+ETCD_TRUSTED_CA_FILE={{.ClientCAFile}}
+ETCD_CERT_FILE={{.ClientCertFile}}
+ETCD_KEY_FILE={{.ClientKeyFile}}
+ETCD_CLIENT_CERT_AUTH={{eq .ClientScheme "https"}}
+ETCD_PEER_TRUSTED_CA_FILE={{.PeerCAFile}}
+ETCD_PEER_CERT_FILE={{.PeerCertFile}}
+ETCD_PEER_KEY_FILE={{.PeerKeyFile}}
+ETCD_PEER_CLIENT_CERT_AUTH={{eq .PeerScheme "https"}}
+{{with .Tuning.DataDir}}ETCD_DATA_DIR={{.}}
+{{end}}{{with .Tuning.HeartbeatInterval}}ETCD_HEARTBEAT_INTERVAL={{.}}
+{{end}}{{with .Tuning.ElectionTimeout}}ETCD_ELECTION_TIMEOUT={{.}}
+{{end}}{{with .Tuning.QuotaBackendBytes}}ETCD_QUOTA_BACKEND_BYTES={{.}}
+{{end}}{{with .Tuning.SnapshotCount}}ETCD_SNAPSHOT_COUNT={{.}}
+{{end}}{{with .Tuning.AutoCompactionMode}}ETCD_AUTO_COMPACTION_MODE={{.}}
+{{end}}{{with .Tuning.AutoCompactionRetention}}ETCD_AUTO_COMPACTION_RETENTION={{.}}
+{{end}}`))
+
+func (envRenderer) Render(r *RealizedConfig) ([]byte, error) {
+	b := bytes.NewBuffer(nil)
+	err := envTemplate.Execute(b, r)
+	return b.Bytes(), err
+}
+
+// fileConfig is the realized config in the layout of the etcd --config-file,
+// which is also used for the json output.
+type fileConfig struct {
+	Name                     string            `yaml:"name" json:"name"`
+	InitialClusterState      string            `yaml:"initial-cluster-state" json:"initial-cluster-state"`
+	Proxy                    string            `yaml:"proxy,omitempty" json:"proxy,omitempty"`
+	InitialCluster           string            `yaml:"initial-cluster,omitempty" json:"initial-cluster,omitempty"`
+	DiscoverySRV             string            `yaml:"discovery-srv,omitempty" json:"discovery-srv,omitempty"`
+	ListenClientURLs         string            `yaml:"listen-client-urls" json:"listen-client-urls"`
+	ListenPeerURLs           string            `yaml:"listen-peer-urls" json:"listen-peer-urls"`
+	ListenMetricsURLs        string            `yaml:"listen-metrics-urls,omitempty" json:"listen-metrics-urls,omitempty"`
+	InitialAdvertisePeerURLs string            `yaml:"initial-advertise-peer-urls" json:"initial-advertise-peer-urls"`
+	AdvertiseClientURLs      string            `yaml:"advertise-client-urls" json:"advertise-client-urls"`
+	DataDir                  string            `yaml:"data-dir,omitempty" json:"data-dir,omitempty"`
+	HeartbeatInterval        int64             `yaml:"heartbeat-interval,omitempty" json:"heartbeat-interval,omitempty"`
+	ElectionTimeout          int64             `yaml:"election-timeout,omitempty" json:"election-timeout,omitempty"`
+	QuotaBackendBytes        int64             `yaml:"quota-backend-bytes,omitempty" json:"quota-backend-bytes,omitempty"`
+	SnapshotCount            int64             `yaml:"snapshot-count,omitempty" json:"snapshot-count,omitempty"`
+	AutoCompactionMode       string            `yaml:"auto-compaction-mode,omitempty" json:"auto-compaction-mode,omitempty"`
+	AutoCompactionRetention  string            `yaml:"auto-compaction-retention,omitempty" json:"auto-compaction-retention,omitempty"`
+	ClientTransportSecurity  transportSecurity `yaml:"client-transport-security" json:"client-transport-security"`
+	PeerTransportSecurity    transportSecurity `yaml:"peer-transport-security" json:"peer-transport-security"`
+}
+
+type transportSecurity struct {
+	TrustedCAFile  string `yaml:"trusted-ca-file,omitempty" json:"trusted-ca-file,omitempty"`
+	CertFile       string `yaml:"cert-file,omitempty" json:"cert-file,omitempty"`
+	KeyFile        string `yaml:"key-file,omitempty" json:"key-file,omitempty"`
+	ClientCertAuth bool   `yaml:"client-cert-auth" json:"client-cert-auth"`
+}
+
+func newFileConfig(r *RealizedConfig) (fileConfig, error) {
+	f := fileConfig{
+		Name:                     r.Name,
+		InitialClusterState:      r.ClusterState,
+		Proxy:                    r.Proxy,
+		DiscoverySRV:             r.DiscoverySRV,
+		ListenClientURLs:         r.ListenClientURL,
+		ListenPeerURLs:           r.ListenPeerURL,
+		ListenMetricsURLs:        r.ListenMetricsURL,
+		InitialAdvertisePeerURLs: r.InitialAdvertisePeerURL,
+		AdvertiseClientURLs:      r.InitialAdvertiseClientURL,
+		DataDir:                  r.Tuning.DataDir,
+		AutoCompactionMode:       r.Tuning.AutoCompactionMode,
+		AutoCompactionRetention:  r.Tuning.AutoCompactionRetention,
+		ClientTransportSecurity: transportSecurity{
+			TrustedCAFile:  r.ClientCAFile,
+			CertFile:       r.ClientCertFile,
+			KeyFile:        r.ClientKeyFile,
+			ClientCertAuth: r.ClientScheme == "https",
+		},
+		PeerTransportSecurity: transportSecurity{
+			TrustedCAFile:  r.PeerCAFile,
+			CertFile:       r.PeerCertFile,
+			KeyFile:        r.PeerKeyFile,
+			ClientCertAuth: r.PeerScheme == "https",
+		},
+	}
+	// etcd rejects an explicit initial cluster alongside srv discovery.
+	if r.DiscoverySRV == "" {
+		f.InitialCluster = strings.Join(r.InitialCluster, ",")
+	}
+
+	// The config file takes numbers, unset values are left to etcd.
+	for _, n := range []struct {
+		value string
+		out   *int64
+	}{
+		{r.Tuning.HeartbeatInterval, &f.HeartbeatInterval},
+		{r.Tuning.ElectionTimeout, &f.ElectionTimeout},
+		{r.Tuning.QuotaBackendBytes, &f.QuotaBackendBytes},
+		{r.Tuning.SnapshotCount, &f.SnapshotCount},
+	} {
+		if n.value == "" {
+			continue
+		}
+		v, err := strconv.ParseInt(n.value, 10, 64)
+		if err != nil {
+			return f, fmt.Errorf("controller: invalid tuning value: %s", n.value)
+		}
+		*n.out = v
+	}
+	return f, nil
+}
+
+// yamlRenderer renders a config file for etcd --config-file.
+type yamlRenderer struct{}
+
+func (yamlRenderer) Render(r *RealizedConfig) ([]byte, error) {
+	f, err := newFileConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(f)
+}
+
+// jsonRenderer renders the etcd config file layout as json for other tooling.
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(r *RealizedConfig) ([]byte, error) {
+	f, err := newFileConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// flagsRenderer renders etcd command line flags, one per line. Flags without
+// a value are left out.
+type flagsRenderer struct{}
+
+func (flagsRenderer) Render(r *RealizedConfig) ([]byte, error) {
+	flags, err := etcdFlags(r)
+	if err != nil {
+		return nil, err
+	}
+	b := bytes.NewBuffer(nil)
+	for _, flag := range flags {
+		fmt.Fprintln(b, flag)
+	}
+	return b.Bytes(), nil
+}
+
+// etcdFlags returns the realized config as etcd command line flags, leaving
+// out the flags without a value.
+func etcdFlags(r *RealizedConfig) ([]string, error) {
+	f, err := newFileConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	flags := []struct{ name, value string }{
+		{"name", f.Name},
+		{"initial-cluster-state", f.InitialClusterState},
+		{"proxy", f.Proxy},
+		{"initial-cluster", f.InitialCluster},
+		{"discovery-srv", f.DiscoverySRV},
+		{"listen-client-urls", f.ListenClientURLs},
+		{"listen-peer-urls", f.ListenPeerURLs},
+		{"listen-metrics-urls", f.ListenMetricsURLs},
+		{"initial-advertise-peer-urls", f.InitialAdvertisePeerURLs},
+		{"advertise-client-urls", f.AdvertiseClientURLs},
+		{"data-dir", r.Tuning.DataDir},
+		{"heartbeat-interval", r.Tuning.HeartbeatInterval},
+		{"election-timeout", r.Tuning.ElectionTimeout},
+		{"quota-backend-bytes", r.Tuning.QuotaBackendBytes},
+		{"snapshot-count", r.Tuning.SnapshotCount},
+		{"auto-compaction-mode", r.Tuning.AutoCompactionMode},
+		{"auto-compaction-retention", r.Tuning.AutoCompactionRetention},
+		{"trusted-ca-file", f.ClientTransportSecurity.TrustedCAFile},
+		{"cert-file", f.ClientTransportSecurity.CertFile},
+		{"key-file", f.ClientTransportSecurity.KeyFile},
+		{"client-cert-auth", strconv.FormatBool(f.ClientTransportSecurity.ClientCertAuth)},
+		{"peer-trusted-ca-file", f.PeerTransportSecurity.TrustedCAFile},
+		{"peer-cert-file", f.PeerTransportSecurity.CertFile},
+		{"peer-key-file", f.PeerTransportSecurity.KeyFile},
+		{"peer-client-cert-auth", strconv.FormatBool(f.PeerTransportSecurity.ClientCertAuth)},
+	}
+	var out []string
+	for _, flag := range flags {
+		if flag.value != "" {
+			out = append(out, "--"+flag.name+"="+flag.value)
+		}
+	}
+	return out, nil
+}
+
+// outputRenderer returns the renderer of an output format. The template
+// format executes the configured template file, which is read on every run.
+func outputRenderer(format string, config *Config) (Renderer, error) {
+	if format != "template" {
+		return renderers[format], nil
+	}
+	if config.TemplateFile == "" {
+		return nil, errors.New("controller: the template output needs a template file")
+	}
+	return newTemplateRenderer(config.TemplateFile, config)
+}
+
+// templateRenderer executes an operator supplied template against the
+// realized config, with the discovered cluster under .Cluster.
+type templateRenderer struct {
+	tpl    *template.Template
+	config *Config
+}
+
+// TemplateData is the data output templates are executed against.
+type TemplateData struct {
+	*RealizedConfig
+	Cluster *Config
+}
+
+func newTemplateRenderer(name string, config *Config) (Renderer, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	tpl, err := template.New(filepath.Base(name)).Funcs(templateFuncs(config.Config)).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("controller: invalid template %s: %v", name, err)
+	}
+	return templateRenderer{tpl: tpl, config: config}, nil
+}
+
+// templateFuncs are the helpers available to output templates, join works on
+// string lists and the url helpers build member urls from a host.
+func templateFuncs(cfg etcd.Config) template.FuncMap {
+	return template.FuncMap{
+		"join":      strings.Join,
+		"peerURL":   cfg.PeerURL,
+		"clientURL": cfg.ClientURL,
+	}
+}
+
+func (t templateRenderer) Render(r *RealizedConfig) ([]byte, error) {
+	b := bytes.NewBuffer(nil)
+	err := t.tpl.Execute(b, TemplateData{RealizedConfig: r, Cluster: t.config})
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// output is a file written in one of the output formats.
+type output struct {
+	format string
+	path   string
+}
+
+// parseOutputs parses the extra outputs, each of the form <format>:<path>.
+func parseOutputs(specs []string) ([]output, error) {
+	var outputs []output
+	for _, spec := range specs {
+		parts := strings.SplitN(spec, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("controller: invalid output, expected <format>:<path>: %s", spec)
+		}
+		if _, ok := renderers[parts[0]]; !ok && parts[0] != "template" {
+			return nil, fmt.Errorf("controller: unknown output format: %s", parts[0])
+		}
+		outputs = append(outputs, output{format: parts[0], path: parts[1]})
+	}
+	return outputs, nil
+}
@@ -0,0 +1,214 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"github.com/stretchr/testify/require"
+)
+
+func configVars(t *testing.T, r *RealizedConfig) string {
+	out, err := r.ConfigVars()
+	require.Nil(t, err)
+	return string(out)
+}
+
+func testRealized() *RealizedConfig {
+	cfg := etcdTestConfig
+	cfg.ClientCAFile = "/etc/etcd/certs/ca.pem"
+	cfg.ClientCertFile = "/etc/etcd/certs/etcd.pem"
+	cfg.ClientKeyFile = "/etc/etcd/certs/etcd-key.pem"
+	cfg.PeerScheme = "http"
+	return &RealizedConfig{
+		Config:                    cfg,
+		ClusterState:              "new",
+		Name:                      "1",
+		InitialCluster:            []string{"1=https://1.ec2.internal:2380", "2=https://2.ec2.internal:2380"},
+		InitialAdvertisePeerURL:   "https://1.ec2.internal:2380",
+		InitialAdvertiseClientURL: "https://1.ec2.internal:2379",
+		ListenClientURL:           "https://0.0.0.0:2379",
+		ListenPeerURL:             "https://0.0.0.0:2380",
+	}
+}
+
+func TestRender_YAML(t *testing.T) {
+	out, err := renderers["yaml"].Render(testRealized())
+	require.Nil(t, err)
+	require.Equal(t, `name: "1"
+initial-cluster-state: new
+initial-cluster: 1=https://1.ec2.internal:2380,2=https://2.ec2.internal:2380
+listen-client-urls: https://0.0.0.0:2379
+listen-peer-urls: https://0.0.0.0:2380
+initial-advertise-peer-urls: https://1.ec2.internal:2380
+advertise-client-urls: https://1.ec2.internal:2379
+client-transport-security:
+  trusted-ca-file: /etc/etcd/certs/ca.pem
+  cert-file: /etc/etcd/certs/etcd.pem
+  key-file: /etc/etcd/certs/etcd-key.pem
+  client-cert-auth: true
+peer-transport-security:
+  client-cert-auth: false
+`, string(out))
+}
+
+func TestRender_JSON(t *testing.T) {
+	r := testRealized()
+	r.DiscoverySRV = "etcd.internal"
+	out, err := renderers["json"].Render(r)
+	require.Nil(t, err)
+	require.Equal(t, `{
+  "name": "1",
+  "initial-cluster-state": "new",
+  "discovery-srv": "etcd.internal",
+  "listen-client-urls": "https://0.0.0.0:2379",
+  "listen-peer-urls": "https://0.0.0.0:2380",
+  "initial-advertise-peer-urls": "https://1.ec2.internal:2380",
+  "advertise-client-urls": "https://1.ec2.internal:2379",
+  "client-transport-security": {
+    "trusted-ca-file": "/etc/etcd/certs/ca.pem",
+    "cert-file": "/etc/etcd/certs/etcd.pem",
+    "key-file": "/etc/etcd/certs/etcd-key.pem",
+    "client-cert-auth": true
+  },
+  "peer-transport-security": {
+    "client-cert-auth": false
+  }
+}
+`, string(out))
+}
+
+func TestRender_Flags(t *testing.T) {
+	out, err := renderers["flags"].Render(testRealized())
+	require.Nil(t, err)
+	require.Equal(t, `--name=1
+--initial-cluster-state=new
+--initial-cluster=1=https://1.ec2.internal:2380,2=https://2.ec2.internal:2380
+--listen-client-urls=https://0.0.0.0:2379
+--listen-peer-urls=https://0.0.0.0:2380
+--initial-advertise-peer-urls=https://1.ec2.internal:2380
+--advertise-client-urls=https://1.ec2.internal:2379
+--trusted-ca-file=/etc/etcd/certs/ca.pem
+--cert-file=/etc/etcd/certs/etcd.pem
+--key-file=/etc/etcd/certs/etcd-key.pem
+--client-cert-auth=true
+--peer-client-cert-auth=false
+`, string(out))
+}
+
+func TestParseOutputs(t *testing.T) {
+	outputs, err := parseOutputs([]string{"yaml:/etc/etcd/etcd.yaml", "flags:/run/etcd/flags"})
+	require.Nil(t, err)
+	require.Equal(t, []output{
+		{format: "yaml", path: "/etc/etcd/etcd.yaml"},
+		{format: "flags", path: "/run/etcd/flags"},
+	}, outputs)
+
+	_, err = parseOutputs([]string{"/etc/etcd/etcd.yaml"})
+	require.NotNil(t, err)
+	_, err = parseOutputs([]string{"toml:/etc/etcd/etcd.toml"})
+	require.NotNil(t, err)
+}
+
+func TestController_WriteOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outputs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	realized := testRealized()
+	realized.EnvFile = filepath.Join(dir, "config")
+	realized.Outputs = []string{
+		"yaml:" + filepath.Join(dir, "etcd.yaml"),
+		"json:" + filepath.Join(dir, "etcd.json"),
+	}
+	require.Nil(t, (&Controller{}).writeEnvFiles(&Config{Config: realized.Config}, realized))
+
+	for _, name := range []string{"config", "etcd.yaml", "etcd.json"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		require.Nil(t, err)
+		require.Contains(t, string(data), "https://1.ec2.internal:2380")
+	}
+
+	realized.Outputs = []string{"toml:" + filepath.Join(dir, "etcd.toml")}
+	require.NotNil(t, (&Controller{}).writeEnvFiles(&Config{Config: realized.Config}, realized))
+}
+
+func TestRender_Template(t *testing.T) {
+	dir, err := ioutil.TempDir("", "template-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "dropin.tpl")
+	require.Nil(t, ioutil.WriteFile(name, []byte(`[Service]
+Environment=ETCD_NAME={{.Name}}
+Environment=ETCD_INITIAL_CLUSTER={{join .InitialCluster ","}}
+{{- range $id, $host := .Cluster.Instances}}
+# {{$id}} {{peerURL $host}} {{clientURL $host}}
+{{- end}}
+`), 0644))
+
+	realized := testRealized()
+	config := &Config{
+		Config:    realized.Config,
+		Instances: map[string]string{"1": "1.ec2.internal", "2": "2.ec2.internal"},
+	}
+	config.TemplateFile = name
+
+	renderer, err := outputRenderer("template", config)
+	require.Nil(t, err)
+	out, err := renderer.Render(realized)
+	require.Nil(t, err)
+	require.Equal(t, `[Service]
+Environment=ETCD_NAME=1
+Environment=ETCD_INITIAL_CLUSTER=1=https://1.ec2.internal:2380,2=https://2.ec2.internal:2380
+# 1 http://1.ec2.internal:2379 https://1.ec2.internal:2380
+# 2 http://2.ec2.internal:2379 https://2.ec2.internal:2380
+`, string(out))
+
+	// Template errors are returned rather than panicking.
+	require.Nil(t, ioutil.WriteFile(name, []byte(`{{.Missing}}`), 0644))
+	renderer, err = outputRenderer("template", config)
+	require.Nil(t, err)
+	_, err = renderer.Render(realized)
+	require.NotNil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(name, []byte(`{{if}}`), 0644))
+	_, err = outputRenderer("template", config)
+	require.NotNil(t, err)
+
+	config.TemplateFile = ""
+	_, err = outputRenderer("template", config)
+	require.NotNil(t, err)
+}
+
+func TestRender_Tuning(t *testing.T) {
+	r := testRealized()
+	r.Tuning = etcd.Tuning{
+		DataDir:            "/var/lib/etcd",
+		HeartbeatInterval:  "250",
+		ElectionTimeout:    "2500",
+		AutoCompactionMode: "periodic",
+	}
+
+	out, err := renderers["env"].Render(r)
+	require.Nil(t, err)
+	require.Contains(t, string(out), "ETCD_PEER_CLIENT_CERT_AUTH=false\nETCD_DATA_DIR=/var/lib/etcd\nETCD_HEARTBEAT_INTERVAL=250\nETCD_ELECTION_TIMEOUT=2500\nETCD_AUTO_COMPACTION_MODE=periodic\n")
+
+	out, err = renderers["yaml"].Render(r)
+	require.Nil(t, err)
+	require.Contains(t, string(out), "data-dir: /var/lib/etcd\nheartbeat-interval: 250\nelection-timeout: 2500\nauto-compaction-mode: periodic\n")
+
+	out, err = renderers["json"].Render(r)
+	require.Nil(t, err)
+	require.Contains(t, string(out), `"heartbeat-interval": 250,`)
+
+	out, err = renderers["flags"].Render(r)
+	require.Nil(t, err)
+	require.Contains(t, string(out), "--data-dir=/var/lib/etcd\n--heartbeat-interval=250\n--election-timeout=2500\n--auto-compaction-mode=periodic\n")
+
+	r.Tuning.SnapshotCount = "many"
+	_, err = renderers["yaml"].Render(r)
+	require.NotNil(t, err)
+}
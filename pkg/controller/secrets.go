@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"log"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+)
+
+type secretFile struct {
+	Ref  string
+	File string
+}
+
+func secretFiles(cfg etcd.Config) (out []secretFile) {
+	for _, s := range []secretFile{
+		{cfg.ClientCASecret, cfg.ClientCAFile},
+		{cfg.ClientCertSecret, cfg.ClientCertFile},
+		{cfg.ClientKeySecret, cfg.ClientKeyFile},
+		{cfg.PeerCASecret, cfg.PeerCAFile},
+		{cfg.PeerCertSecret, cfg.PeerCertFile},
+		{cfg.PeerKeySecret, cfg.PeerKeyFile},
+	} {
+		if s.Ref != "" {
+			out = append(out, s)
+		}
+	}
+	return
+}
+
+// syncSecrets writes every configured secret to its certificate file. It is
+// called at the start of every run, before the etcd client connects, so that
+// rotated secrets are picked up in watch mode. The files get the env file
+// owner so etcd can read them when it runs as another user.
+func syncSecrets(a aws.Client, cfg etcd.Config) error {
+	uid, gid, err := fileOwner(cfg)
+	if err != nil {
+		return err
+	}
+	for _, s := range secretFiles(cfg) {
+		data, err := a.Secret(s.Ref)
+		if err != nil {
+			return err
+		}
+		changed, err := writeOwnedFile(s.File, data, 0600, uid, gid)
+		if err != nil {
+			return err
+		}
+		if changed {
+			log.Printf("wrote secret %s to %s", s.Ref, s.File)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	a := &MockAWS{}
+	a.On("Secret", "ssm:/etcd/ca.pem").Return([]byte("ca"), nil)
+	a.On("Secret", "secretsmanager:etcd/key").Return([]byte("key"), nil)
+
+	cfg := etcd.Config{
+		ClientCAFile:    filepath.Join(dir, "ca.pem"),
+		ClientCASecret:  "ssm:/etcd/ca.pem",
+		ClientKeyFile:   filepath.Join(dir, "certs", "etcd-key.pem"),
+		ClientKeySecret: "secretsmanager:etcd/key",
+		ClientCertFile:  filepath.Join(dir, "etcd.pem"),
+		EnvFileUID:      strconv.Itoa(os.Getuid()),
+		EnvFileGID:      strconv.Itoa(os.Getgid()),
+	}
+
+	require.Nil(t, syncSecrets(a, cfg))
+
+	data, err := ioutil.ReadFile(cfg.ClientCAFile)
+	require.Nil(t, err)
+	require.Equal(t, "ca", string(data))
+
+	info, err := os.Stat(cfg.ClientKeyFile)
+	require.Nil(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	_, err = os.Stat(cfg.ClientCertFile)
+	require.True(t, os.IsNotExist(err))
+
+	a.AssertExpectations(t)
+
+	cfg.EnvFileUID = "etcd"
+	require.NotNil(t, syncSecrets(a, cfg))
+}
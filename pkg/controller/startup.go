@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/coldog/etcd-aws-cluster/pkg/discovery"
+	"github.com/coldog/etcd-aws-cluster/pkg/etcd"
+)
+
+// Bounds of the backoff between startup checks.
+var (
+	startupBackoff    = time.Second
+	startupMaxBackoff = 30 * time.Second
+)
+
+var sleep = time.Sleep
+
+// StartupDeadline returns when startup gives up waiting, from the configured
+// startup timeout.
+func StartupDeadline(cfg etcd.Config) (time.Time, error) {
+	timeout, err := time.ParseDuration(cfg.StartupTimeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Now().Add(timeout), nil
+}
+
+// WaitFor retries check with a doubling backoff until it succeeds. On a fresh
+// boot the metadata service, the autoscaling group and the certificates may
+// all lag behind this process, so failures are logged and retried until the
+// deadline, after which the last error is returned.
+func WaitFor(deadline time.Time, what string, check func() error) error {
+	delay := startupBackoff
+	for attempt := 1; ; attempt++ {
+		err := check()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("%s ready after %d attempts", what, attempt)
+			}
+			return nil
+		}
+		left := time.Until(deadline)
+		if left <= 0 {
+			return fmt.Errorf("gave up waiting for %s: %v", what, err)
+		}
+		if delay > left {
+			delay = left
+		}
+		log.Printf("waiting for %s (attempt %d, retrying in %s): %v", what, attempt, delay, err)
+		sleep(delay)
+		if delay *= 2; delay > startupMaxBackoff {
+			delay = startupMaxBackoff
+		}
+	}
+}
+
+// WaitInService waits for this instance to enter service in its autoscaling
+// group, before that its own state would keep it out of a new cluster.
+func WaitInService(a aws.Client, deadline time.Time) error {
+	return WaitFor(deadline, "instance to be InService", func() error {
+		state, err := a.LifecycleState()
+		if err != nil {
+			return err
+		}
+		if state != discovery.StateInService {
+			return fmt.Errorf("instance is %s", state)
+		}
+		return nil
+	})
+}
+
+// WaitCertificates waits for the certificate files of every tls scheme to
+// hold data, for when another process is still writing them. Files written
+// from secrets or issued from the CA bucket are left to Run.
+func WaitCertificates(cfg etcd.Config, deadline time.Time) error {
+	return WaitFor(deadline, "certificate files", func() error {
+		for _, f := range cfg.CertificateFiles() {
+			if f.Managed {
+				continue
+			}
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				return err
+			}
+			if info.Size() == 0 {
+				return fmt.Errorf("%s is empty", f.Path)
+			}
+		}
+		return nil
+	})
+}
+
+func certificateFiles(cfg etcd.Config) (files []string) {
+	for _, f := range cfg.CertificateFiles() {
+		files = append(files, f.Path)
+	}
+	return files
+}
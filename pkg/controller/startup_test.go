@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSleep records the startup backoff instead of sleeping.
+func fakeSleep() *[]time.Duration {
+	var delays []time.Duration
+	sleep = func(d time.Duration) { delays = append(delays, d) }
+	return &delays
+}
+
+func TestWaitFor(t *testing.T) {
+	delays := fakeSleep()
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	err := WaitFor(time.Now().Add(time.Hour), "test", func() error {
+		calls++
+		if calls < 7 {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, 7, calls)
+	require.Equal(t, []time.Duration{
+		time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+		16 * time.Second, 30 * time.Second,
+	}, *delays)
+}
+
+func TestWaitFor_Deadline(t *testing.T) {
+	fakeSleep()
+	defer func() { sleep = time.Sleep }()
+
+	calls := 0
+	err := WaitFor(time.Now(), "test", func() error {
+		calls++
+		return errors.New("not ready")
+	})
+	require.Equal(t, "gave up waiting for test: not ready", err.Error())
+	require.Equal(t, 1, calls)
+}
+
+func TestWaitInService(t *testing.T) {
+	delays := fakeSleep()
+	defer func() { sleep = time.Sleep }()
+
+	a := &MockAWS{}
+	a.On("LifecycleState").Return("", errors.New("aws: autoscaling group not found")).Once()
+	a.On("LifecycleState").Return("Pending", nil).Once()
+	a.On("LifecycleState").Return("InService", nil).Once()
+
+	require.Nil(t, WaitInService(a, time.Now().Add(time.Hour)))
+	require.Len(t, *delays, 2)
+	a.AssertExpectations(t)
+}
+
+func TestWaitCertificates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "startup")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := etcdTestConfig
+	cfg.ClientScheme = "https"
+	cfg.PeerScheme = "http"
+	cfg.ClientCAFile = filepath.Join(dir, "ca.pem")
+	cfg.ClientCertFile = filepath.Join(dir, "etcd.pem")
+	cfg.ClientKeyFile = filepath.Join(dir, "etcd-key.pem")
+	require.Equal(t, []string{cfg.ClientCAFile, cfg.ClientCertFile, cfg.ClientKeyFile}, certificateFiles(cfg))
+
+	// The files show up while waiting.
+	written := false
+	sleep = func(time.Duration) {
+		if !written {
+			for _, name := range certificateFiles(cfg) {
+				require.Nil(t, ioutil.WriteFile(name, []byte("pem"), 0600))
+			}
+			written = true
+		}
+	}
+	defer func() { sleep = time.Sleep }()
+	require.Nil(t, WaitCertificates(cfg, time.Now().Add(time.Hour)))
+	require.True(t, written)
+
+	require.Nil(t, os.Truncate(cfg.ClientKeyFile, 0))
+	require.NotNil(t, WaitCertificates(cfg, time.Now()))
+
+	// Files from secrets are written by Run and not waited for.
+	cfg.ClientKeySecret = "ssm:/etcd/key"
+	require.Nil(t, WaitCertificates(cfg, time.Now()))
+}
+
+func TestStartupDeadline(t *testing.T) {
+	cfg := etcdTestConfig
+	cfg.StartupTimeout = "1m"
+	deadline, err := StartupDeadline(cfg)
+	require.Nil(t, err)
+	require.True(t, time.Until(deadline) > 59*time.Second)
+
+	cfg.StartupTimeout = "soon"
+	_, err = StartupDeadline(cfg)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+)
+
+// receivesTraffic reports whether a target in the given state is, or is about
+// to be, routed traffic.
+func receivesTraffic(state string) bool {
+	return state != aws.TargetStateDraining && state != aws.TargetStateUnused
+}
+
+// serving reports whether the member of this instance has joined the
+// cluster and serves requests. A member that is up but unhealthy or still
+// catching up does not, nor does a proxy.
+func (c *Controller) serving(config *Config) bool {
+	self := config.InstanceID
+	if _, ok := config.ActiveMembers[self]; !ok || !config.AvailableMembers[self] {
+		return false
+	}
+	return c.etcd.Healthy(config.InstanceHost)
+}
+
+// syncTargets registers the target of this instance once its member serves
+// requests and deregisters it otherwise, without waiting for the drain.
+// Every instance only manages its own target so that one instance failing
+// to reach the others does not drain healthy members. Targets of members
+// that are removed are drained by deregisterTargets.
+func (c *Controller) syncTargets(config *Config) error {
+	if config.TargetGroupARN == "" {
+		return nil
+	}
+	group := c.aws.TargetGroup(config.TargetGroupARN)
+	targets, err := group.Targets()
+	if err != nil {
+		return err
+	}
+
+	self := config.InstanceID
+	state, registered := targets[self]
+	serving := c.serving(config)
+	switch {
+	case serving && (!registered || !receivesTraffic(state)):
+		log.Printf("registering target: %s", self)
+		return group.Register(self)
+	case !serving && registered && receivesTraffic(state):
+		log.Printf("deregistering target: %s", self)
+		return group.Deregister(0, self)
+	}
+	return nil
+}
+
+// deregisterTargets deregisters the targets of members that are about to be
+// removed and waits for their connections to drain, including targets that
+// were already draining.
+func (c *Controller) deregisterTargets(config *Config, ids []string) error {
+	if config.TargetGroupARN == "" || len(ids) == 0 {
+		return nil
+	}
+	timeout, err := time.ParseDuration(config.TargetGroupDrainTimeout)
+	if err != nil {
+		return err
+	}
+	group := c.aws.TargetGroup(config.TargetGroupARN)
+	targets, err := group.Targets()
+	if err != nil {
+		return err
+	}
+
+	var deregister []string
+	for _, id := range ids {
+		if state, ok := targets[id]; ok && state != aws.TargetStateUnused {
+			deregister = append(deregister, id)
+		}
+	}
+	if len(deregister) == 0 {
+		return nil
+	}
+	sort.Strings(deregister)
+	log.Printf("draining targets: %v", deregister)
+	return group.Deregister(timeout, deregister...)
+}
@@ -0,0 +1,150 @@
+package controller
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTargetGroup is an in-memory target group, deregistered targets are
+// drained immediately. Waited lists the targets whose drain was waited for.
+type fakeTargetGroup struct {
+	targets    map[string]string
+	registered []string
+	drained    []string
+	waited     []string
+	err        error
+}
+
+func (g *fakeTargetGroup) Targets() (map[string]string, error) {
+	if g.err != nil {
+		return nil, g.err
+	}
+	out := map[string]string{}
+	for id, state := range g.targets {
+		out[id] = state
+	}
+	return out, nil
+}
+
+func (g *fakeTargetGroup) Register(ids ...string) error {
+	for _, id := range ids {
+		g.targets[id] = "initial"
+	}
+	g.registered = append(g.registered, ids...)
+	return nil
+}
+
+func (g *fakeTargetGroup) Deregister(timeout time.Duration, ids ...string) error {
+	for _, id := range ids {
+		g.targets[id] = aws.TargetStateUnused
+	}
+	g.drained = append(g.drained, ids...)
+	if timeout > 0 {
+		g.waited = append(g.waited, ids...)
+	}
+	return nil
+}
+
+func targetTestConfig() *Config {
+	cfg := etcdTestConfig
+	cfg.TargetGroupARN = "arn"
+	cfg.TargetGroupDrainTimeout = "1m"
+	return &Config{
+		Config: cfg,
+		Instances: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+			"3": "3.ec2.internal",
+			"4": "4.ec2.internal",
+		},
+		AvailableMembers: map[string]bool{"1": true, "2": true, "3": true, "4": false},
+		ActiveMembers: map[string]string{
+			"1": "1.ec2.internal",
+			"2": "2.ec2.internal",
+			"5": "5.ec2.internal",
+		},
+	}
+}
+
+func TestController_SyncTargets(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+	group := &fakeTargetGroup{targets: map[string]string{
+		"2": "healthy",
+		"4": "healthy",
+		"5": "draining",
+	}}
+	a.On("TargetGroup", "arn").Return(group)
+	e.On("Healthy", "1.ec2.internal").Return(true)
+	e.On("Healthy", "2.ec2.internal").Return(false)
+
+	c := &Controller{aws: a, etcd: e}
+	config := targetTestConfig()
+
+	// The healthy member registers its own target and leaves the others
+	// alone, whatever it sees of them.
+	config.InstanceID, config.InstanceHost = "1", "1.ec2.internal"
+	require.Nil(t, c.syncTargets(config))
+	require.Equal(t, []string{"1"}, group.registered)
+	require.Empty(t, group.drained)
+
+	// The unhealthy member deregisters its own target without waiting.
+	config.InstanceID, config.InstanceHost = "2", "2.ec2.internal"
+	require.Nil(t, c.syncTargets(config))
+	require.Equal(t, []string{"2"}, group.drained)
+	require.Empty(t, group.waited)
+
+	// 3 has not joined yet and has no target.
+	config.InstanceID, config.InstanceHost = "3", "3.ec2.internal"
+	require.Nil(t, c.syncTargets(config))
+	require.Equal(t, []string{"1"}, group.registered)
+	require.Equal(t, []string{"2"}, group.drained)
+	e.AssertExpectations(t)
+}
+
+func TestController_DeregisterTargets(t *testing.T) {
+	a := &MockAWS{}
+	group := &fakeTargetGroup{targets: map[string]string{
+		"1": "healthy",
+		"5": "draining",
+		"6": aws.TargetStateUnused,
+	}}
+	a.On("TargetGroup", "arn").Return(group)
+
+	c := &Controller{aws: a}
+	require.Nil(t, c.deregisterTargets(targetTestConfig(), []string{"5", "6", "7"}))
+	require.Equal(t, []string{"5"}, group.drained)
+	require.Equal(t, []string{"5"}, group.waited)
+
+	require.Nil(t, (&Controller{}).deregisterTargets(&Config{Config: etcdTestConfig}, []string{"1"}))
+}
+
+func TestController_RunWritesBeforeTargets(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+
+	cfg := etcdTestConfig
+	cfg.EnvFile = tempFileName()
+	os.Remove(cfg.EnvFile)
+	defer os.Remove(cfg.EnvFile)
+	cfg.TargetGroupARN = "arn"
+
+	a.On("InstanceID").Return("1")
+	a.On("IP").Return("1.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(inService(map[string]string{"1": "1.ec2.internal"}), nil)
+	a.On("TargetGroup", "arn").Return(&fakeTargetGroup{err: errors.New("throttled")})
+	e.On("IsAvailable", "1.ec2.internal").Return(false)
+	e.On("Config").Return(cfg)
+
+	c := &Controller{discovery: asgDiscovery(a), aws: a, etcd: e}
+	require.EqualError(t, c.Run(), "throttled")
+
+	_, err := os.Stat(cfg.EnvFile)
+	require.Nil(t, err)
+}
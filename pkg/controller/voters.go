@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// proxyDataDir is where a proxy keeps its state below the data dir. etcd
+// starts as a proxy again whenever the data dir holds proxy state, so it must
+// not share the data dir of a promoted member.
+const proxyDataDir = "proxy.etcd"
+
+// maxVoters parses the cap on voting members, zero means there is none.
+func maxVoters(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// assignVoters picks the voting members when their number is capped. Current
+// members always keep their vote. Open seats go to the seed instances in
+// order of their id, which every instance agrees on, so surplus instances
+// neither join nor race each other for a seat. Without a cap every instance
+// votes and Voters is left nil.
+func (cfg *Config) assignVoters(max int) {
+	cfg.Voters = nil
+	if max <= 0 {
+		return
+	}
+
+	voters := map[string]bool{}
+	var candidates []string
+	if cfg.AnyAvailable() {
+		for id := range cfg.ActiveMembers {
+			voters[id] = true
+		}
+		for id := range cfg.SeedInstances {
+			if !voters[id] {
+				candidates = append(candidates, id)
+			}
+		}
+	} else {
+		for id := range cfg.SeedInstances {
+			candidates = append(candidates, id)
+		}
+	}
+	sort.Strings(candidates)
+	for _, id := range candidates {
+		if len(voters) >= max {
+			break
+		}
+		voters[id] = true
+	}
+	cfg.Voters = voters
+}
+
+// IsVoter reports whether the instance is, or may become, a voting member.
+func (cfg *Config) IsVoter(id string) bool {
+	return cfg.Voters == nil || cfg.Voters[id]
+}
+
+// proxyConfig turns the realized config of a surplus instance into an etcd
+// proxy of the voting members.
+func proxyConfig(config *Config, realized *RealizedConfig) {
+	realized.Proxy = "on"
+	realized.InitialCluster = config.PeerURLs(clusterMembers(config))
+	realized.Tuning.DataDir = filepath.Join(realized.Tuning.DataDir, proxyDataDir)
+}
+
+// join adds this instance to the cluster unless an earlier run already did.
+// A member that has not started yet is only listed by its peer url, adding
+// it again would fail on the duplicate url.
+func (c *Controller) join(config *Config) error {
+	host := config.AnyAvailableHost()
+	added, err := c.etcd.HasPeer(host, config.InstanceHost)
+	if err != nil {
+		return err
+	}
+	if added {
+		log.Printf("already added to cluster, waiting for the member to start: %s", config.InstanceHost)
+		return nil
+	}
+	log.Printf("adding self to cluster: %s", config.InstanceHost)
+	return c.etcd.Add(host, config.InstanceHost)
+}
+
+var runCommand = func(command string) ([]byte, error) {
+	return exec.Command("/bin/sh", "-c", command).CombinedOutput()
+}
+
+// restartPromoted runs the promote command once a promoted proxy has its
+// member config written. Until etcd is restarted the added member counts
+// towards the quorum without taking part in it, so the command is run on
+// every run until the member has started.
+func restartPromoted(config *Config) error {
+	if config.PromoteCommand == "" {
+		log.Printf("promoted from proxy to member, restart etcd to start the member or set ETCD_PROMOTE_COMMAND")
+		return nil
+	}
+	log.Printf("promoted from proxy to member, restarting etcd: %s", config.PromoteCommand)
+	out, err := runCommand(config.PromoteCommand)
+	if err != nil {
+		return fmt.Errorf("controller: promote command failed: %v: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package controller
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_AssignVotersUncapped(t *testing.T) {
+	cfg := &Config{InstanceID: "4", SeedInstances: map[string]string{"4": "4.ec2.internal"}}
+	cfg.assignVoters(0)
+	require.Nil(t, cfg.Voters)
+	require.True(t, cfg.IsVoter("4"))
+}
+
+func TestConfig_AssignVotersExisting(t *testing.T) {
+	cfg := &Config{
+		InstanceID:       "4",
+		AvailableMembers: map[string]bool{"1": true},
+		ActiveMembers:    map[string]string{"1": "1.ec2.internal", "3": "3.ec2.internal"},
+		SeedInstances: map[string]string{
+			"1": "1.ec2.internal", "2": "2.ec2.internal",
+			"3": "3.ec2.internal", "4": "4.ec2.internal",
+		},
+	}
+	cfg.assignVoters(3)
+	require.Equal(t, map[string]bool{"1": true, "2": true, "3": true}, cfg.Voters)
+	require.False(t, cfg.IsVoter("4"))
+
+	// Members keep their vote past the cap.
+	cfg.assignVoters(1)
+	require.Equal(t, map[string]bool{"1": true, "3": true}, cfg.Voters)
+}
+
+func TestConfig_AssignVotersNew(t *testing.T) {
+	cfg := &Config{
+		InstanceID:    "3",
+		SeedInstances: map[string]string{"1": "1.ec2.internal", "2": "2.ec2.internal", "4": "4.ec2.internal"},
+	}
+	cfg.assignVoters(3)
+	require.Equal(t, map[string]bool{"1": true, "2": true, "4": true}, cfg.Voters)
+
+	// An instance that is not a seed gets no seat, it waits for the cluster.
+	require.False(t, cfg.IsVoter("3"))
+}
+
+func TestController_ProxyRun(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+
+	c := &Controller{
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
+	}
+
+	cfg := etcdTestConfig
+	cfg.MaxVoters = "2"
+	cfg.Tuning.DataDir = "/var/lib/etcd"
+
+	a.On("InstanceID").Return("3")
+	a.On("IP").Return("3.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(inService(map[string]string{
+		"1": "1.ec2.internal",
+		"2": "2.ec2.internal",
+		"3": "3.ec2.internal",
+	}), nil)
+
+	members := map[string]string{
+		"1": "1.ec2.internal",
+		"2": "2.ec2.internal",
+	}
+	e.On("IsAvailable", "1.ec2.internal").Return(true)
+	e.On("IsAvailable", "2.ec2.internal").Return(true)
+	e.On("IsAvailable", "3.ec2.internal").Return(false)
+	e.On("Config").Return(cfg)
+	e.On("Members", "1.ec2.internal").Return(members, nil)
+	e.On("Members", "2.ec2.internal").Return(members, nil)
+
+	require.Nil(t, c.Run())
+	e.AssertNotCalled(t, "Add", "1.ec2.internal", "3.ec2.internal")
+	e.AssertNotCalled(t, "Add", "2.ec2.internal", "3.ec2.internal")
+
+	data, err := ioutil.ReadFile(cfg.EnvFile)
+	require.Nil(t, err)
+	require.Contains(t, string(data), `ETCD_NAME="3"
+ETCD_PROXY="on"
+ETCD_INITIAL_CLUSTER="1=https://1.ec2.internal:2379,2=https://2.ec2.internal:2379"
+`)
+	require.Contains(t, string(data), "ETCD_DATA_DIR=/var/lib/etcd/proxy.etcd\n")
+}
+
+func TestController_PromoteProxyRun(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+
+	c := &Controller{
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
+	}
+
+	cfg := etcdTestConfig
+	cfg.MaxVoters = "2"
+	cfg.Tuning.DataDir = "/var/lib/etcd"
+
+	// The instance of member 2 is gone and the proxy on 3 takes its seat.
+	a.On("InstanceID").Return("3")
+	a.On("IP").Return("3.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(inService(map[string]string{
+		"1": "1.ec2.internal",
+		"3": "3.ec2.internal",
+	}), nil)
+
+	members := map[string]string{
+		"1": "1.ec2.internal",
+		"2": "2.ec2.internal",
+	}
+	e.On("IsAvailable", "1.ec2.internal").Return(true)
+	e.On("IsAvailable", "3.ec2.internal").Return(true)
+	e.On("Config").Return(cfg)
+	e.On("Members", "1.ec2.internal").Return(members, nil)
+	e.On("Members", "3.ec2.internal").Return(members, nil)
+	e.On("Remove", "1.ec2.internal", "2").Return(nil).Once()
+	e.On("Remove", "3.ec2.internal", "2").Return(nil).Once()
+	e.On("HasPeer", mock.Anything, "3.ec2.internal").Return(false, nil).Once()
+	e.On("Add", "1.ec2.internal", "3.ec2.internal").Return(nil).Once()
+	e.On("Add", "3.ec2.internal", "3.ec2.internal").Return(nil).Once()
+
+	require.Nil(t, c.Run())
+	calls := 0
+	for _, call := range e.Calls {
+		if call.Method == "Add" {
+			calls++
+		}
+	}
+	require.Equal(t, 1, calls)
+
+	data, err := ioutil.ReadFile(cfg.EnvFile)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "ETCD_PROXY")
+	require.Contains(t, string(data), `ETCD_INITIAL_CLUSTER="1=https://1.ec2.internal:2379,3=https://3.ec2.internal:2379"`)
+	require.Contains(t, string(data), "ETCD_DATA_DIR=/var/lib/etcd\n")
+}
+
+func TestController_PromotedRestartRun(t *testing.T) {
+	a := &MockAWS{}
+	e := &MockETCD{}
+
+	c := &Controller{
+		discovery: asgDiscovery(a),
+		aws:       a,
+		etcd:      e,
+	}
+
+	cfg := etcdTestConfig
+	cfg.EnvFile = tempFileName()
+	defer os.Remove(cfg.EnvFile)
+	cfg.MaxVoters = "2"
+	cfg.Tuning.DataDir = "/var/lib/etcd"
+	cfg.PromoteCommand = "systemctl restart etcd-member"
+
+	var commands []string
+	runCommand = func(command string) ([]byte, error) {
+		commands = append(commands, command)
+		return nil, nil
+	}
+
+	// A run after the promotion: 3 was added but has not started, so it is
+	// only listed by its peer url and the proxy still answers on 3.
+	a.On("InstanceID").Return("3")
+	a.On("IP").Return("3.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(inService(map[string]string{
+		"1": "1.ec2.internal",
+		"3": "3.ec2.internal",
+	}), nil)
+
+	members := map[string]string{"1": "1.ec2.internal"}
+	e.On("IsAvailable", "1.ec2.internal").Return(true)
+	e.On("IsAvailable", "3.ec2.internal").Return(true)
+	e.On("Config").Return(cfg)
+	e.On("Members", "1.ec2.internal").Return(members, nil)
+	e.On("Members", "3.ec2.internal").Return(members, nil)
+	e.On("HasPeer", mock.Anything, "3.ec2.internal").Return(true, nil)
+
+	require.Nil(t, c.Run())
+	e.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+	require.Equal(t, []string{"systemctl restart etcd-member"}, commands)
+
+	data, err := ioutil.ReadFile(cfg.EnvFile)
+	require.Nil(t, err)
+	require.NotContains(t, string(data), "ETCD_PROXY")
+	require.Contains(t, string(data), `ETCD_INITIAL_CLUSTER="1=https://1.ec2.internal:2379,3=https://3.ec2.internal:2379"`)
+
+	// Once the member has started it is listed by name and left alone.
+	members["3"] = "3.ec2.internal"
+	commands = nil
+	require.Nil(t, c.Run())
+	require.Empty(t, commands)
+	e.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+}
+
+func TestDesiredRecords_Voters(t *testing.T) {
+	cfg := &Config{
+		Instances:   map[string]string{"1": "10.0.0.1", "2": "10.0.0.2"},
+		InstanceIPs: map[string]string{"1": "10.0.0.1", "2": "10.0.0.2"},
+		Voters:      map[string]bool{"1": true},
+	}
+	cfg.Route53Domain = "etcd.internal"
+	cfg.PeerPort = "2380"
+	cfg.ClientPort = "2379"
+	cfg.PeerScheme = "https"
+	cfg.ClientScheme = "https"
+
+	records := desiredRecords(cfg, 60)
+	require.Len(t, records, 4)
+	require.Equal(t, []string{"0 0 2380 1.etcd.internal"}, records[2].Values)
+	require.Equal(t, []string{"0 0 2379 1.etcd.internal", "0 0 2379 2.etcd.internal"}, records[3].Values)
+}
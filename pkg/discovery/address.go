@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+)
+
+// Advertise modes select what is advertised for instances of the aws
+// backends. The same address is used to reach instances and to match them
+// to members, so every instance in a cluster must use the same mode.
+const (
+	AdvertisePrivateIP  = "private-ip"
+	AdvertisePrivateDNS = "private-dns"
+	AdvertiseDNS        = "dns"
+)
+
+// AddressData is passed to the dns name template of the dns mode, for
+// example "{{.InstanceID}}.etcd.internal".
+type AddressData struct {
+	InstanceID string
+	IP         string
+	PrivateDNS string
+	Name       string
+}
+
+// address selects the advertised host of an instance. The zero value uses
+// the private IP.
+type address struct {
+	mode string
+	name *template.Template
+}
+
+func newAddress(mode, name string) (address, error) {
+	switch mode {
+	case "", AdvertisePrivateIP, AdvertisePrivateDNS:
+		return address{mode: mode}, nil
+	case AdvertiseDNS:
+		if name == "" {
+			return address{}, fmt.Errorf("discovery: advertise mode %s requires a name template", mode)
+		}
+		tpl, err := template.New("").Option("missingkey=error").Parse(name)
+		if err != nil {
+			return address{}, err
+		}
+		// Execute once so unknown fields fail here rather than per instance.
+		if err = tpl.Execute(&bytes.Buffer{}, AddressData{}); err != nil {
+			return address{}, err
+		}
+		return address{mode: mode, name: tpl}, nil
+	}
+	return address{}, fmt.Errorf("discovery: unknown advertise mode: %s", mode)
+}
+
+// host returns the advertised host, which is empty when the instance does
+// not have the selected address yet.
+func (a address) host(data AddressData) string {
+	switch a.mode {
+	case AdvertisePrivateDNS:
+		return data.PrivateDNS
+	case AdvertiseDNS:
+		b := &bytes.Buffer{}
+		if a.name.Execute(b, data) != nil {
+			return ""
+		}
+		return b.String()
+	}
+	return data.IP
+}
+
+// self returns the advertised host of this instance. The name is only
+// looked up when the template needs it.
+func (a address) self(c aws.Client, name func() string) string {
+	switch a.mode {
+	case AdvertisePrivateDNS:
+		return c.Hostname()
+	case AdvertiseDNS:
+		return a.host(AddressData{
+			InstanceID: c.InstanceID(),
+			IP:         c.IP(),
+			PrivateDNS: c.Hostname(),
+			Name:       name(),
+		})
+	}
+	return c.IP()
+}
+
+// instances converts aws instances to discovered instances.
+func (a address) instances(name string, instances map[string]aws.Instance, err error) (map[string]Instance, error) {
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]Instance{}
+	for id, inst := range instances {
+		out[id] = Instance{
+			Host: a.host(AddressData{
+				InstanceID: id,
+				IP:         inst.IP,
+				PrivateDNS: inst.PrivateDNS,
+				Name:       name,
+			}),
+			IP:      inst.IP,
+			State:   inst.LifecycleState,
+			Healthy: inst.HealthStatus != "Unhealthy",
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAddress_Modes(t *testing.T) {
+	a := &MockAWS{}
+	a.On("InstanceID").Return("i-1")
+	a.On("IP").Return("10.0.0.1")
+	a.On("Hostname").Return("ip-10-0-0-1.ec2.internal")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(map[string]aws.Instance{
+		"i-1": {IP: "10.0.0.1", PrivateDNS: "ip-10-0-0-1.ec2.internal", LifecycleState: "InService"},
+		"i-2": {IP: "10.0.0.2", LifecycleState: "Pending"},
+	}, nil)
+
+	for _, test := range []struct {
+		mode, name string
+		self       string
+		hosts      map[string]string
+	}{
+		{AdvertisePrivateIP, "", "10.0.0.1", map[string]string{"i-1": "10.0.0.1", "i-2": "10.0.0.2"}},
+		{AdvertisePrivateDNS, "", "ip-10-0-0-1.ec2.internal", map[string]string{"i-1": "ip-10-0-0-1.ec2.internal", "i-2": ""}},
+		{AdvertiseDNS, "{{.InstanceID}}.{{.Name}}.etcd.internal", "i-1.test.etcd.internal", map[string]string{"i-1": "i-1.test.etcd.internal", "i-2": "i-2.test.etcd.internal"}},
+	} {
+		d, err := New(Config{Backend: "asg", Advertise: test.mode, AdvertiseName: test.name}, a)
+		require.Nil(t, err)
+		require.Equal(t, test.self, d.Host(), test.mode)
+
+		instances, err := d.Instances()
+		require.Nil(t, err)
+		hosts := map[string]string{}
+		for id, inst := range instances {
+			hosts[id] = inst.Host
+		}
+		require.Equal(t, test.hosts, hosts, test.mode)
+	}
+}
+
+func TestAddress_Invalid(t *testing.T) {
+	for _, c := range []Config{
+		{Backend: "asg", Advertise: "public-ip"},
+		{Backend: "asg", Advertise: AdvertiseDNS},
+		{Backend: "asg", Advertise: AdvertiseDNS, AdvertiseName: "{{.Zone}}.etcd.internal"},
+		{Backend: "srv", Advertise: AdvertisePrivateDNS},
+	} {
+		_, err := New(c, nil)
+		require.NotNil(t, err, c.Advertise)
+	}
+}
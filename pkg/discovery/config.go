@@ -0,0 +1,78 @@
+package discovery
+
+import (
+	"os"
+	"strings"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/settings"
+)
+
+type Config struct {
+	Backend    string
+	Tag        string
+	File       string
+	SRVDomain  string
+	SRVService string
+	SelfID     string
+	SelfHost   string
+	SeedStates []string
+	KeepStates []string
+
+	// Advertise selects the address advertised for instances of the aws
+	// backends, AdvertiseName is the dns name template for the dns mode.
+	Advertise     string
+	AdvertiseName string
+}
+
+// Policy returns the lifecycle state policy, unset lists use the defaults.
+func (c Config) Policy() Policy {
+	return Policy{SeedStates: c.SeedStates, KeepStates: c.KeepStates}
+}
+
+// NeedsAWS reports whether the selected backend uses the aws client.
+func (c Config) NeedsAWS() bool {
+	switch c.Backend {
+	case "asg", "asg-tag", "ec2-tag":
+		return true
+	}
+	return false
+}
+
+// NeedsGroup reports whether the selected backend discovers instances from
+// autoscaling groups, whose lifecycle states the instance should wait for.
+func (c Config) NeedsGroup() bool {
+	return c.Backend == "asg" || c.Backend == "asg-tag"
+}
+
+// env resolves a setting from the flags, the environment or the config file.
+func env(name, defaults string) string {
+	return settings.Get(name, defaults)
+}
+
+func envList(name, defaults string) (out []string) {
+	for _, item := range strings.Split(env(name, defaults), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return
+}
+
+func GetEnvConfig() Config {
+	hostname, _ := os.Hostname()
+	return Config{
+		Backend:    env("ETCD_DISCOVERY", "asg"),
+		Tag:        env("ETCD_DISCOVERY_TAG", ""),
+		File:       env("ETCD_DISCOVERY_FILE", "/etc/etcd/peers.yaml"),
+		SRVDomain:  env("ETCD_DISCOVERY_SRV_DOMAIN", ""),
+		SRVService: env("ETCD_DISCOVERY_SRV_SERVICE", "etcd-server-ssl"),
+		SelfID:     env("ETCD_DISCOVERY_SELF_ID", hostname),
+		SelfHost:   env("ETCD_DISCOVERY_SELF_HOST", ""),
+		SeedStates: envList("ETCD_DISCOVERY_SEED_STATES", ""),
+		KeepStates: envList("ETCD_DISCOVERY_KEEP_STATES", ""),
+
+		Advertise:     env("ETCD_DISCOVERY_ADVERTISE", AdvertisePrivateIP),
+		AdvertiseName: env("ETCD_DISCOVERY_ADVERTISE_NAME", ""),
+	}
+}
@@ -0,0 +1,106 @@
+package discovery
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+)
+
+// Discovery identifies this instance and finds the instances that should form
+// the cluster. Instances are keyed by instance ID, which is used as the etcd
+// member name.
+type Discovery interface {
+	InstanceID() string
+	Host() string
+	Name() string
+	Instances() (map[string]Instance, error)
+}
+
+// Instance is a discovered instance. Host is what peers and clients connect
+// to, IP is the private IP when known and State is an autoscaling lifecycle
+// state.
+type Instance struct {
+	Host    string
+	IP      string
+	State   string
+	Healthy bool
+}
+
+// New creates the discovery backend selected in the config. The aws client
+// is only used by the aws backends and may be nil otherwise.
+func New(c Config, a aws.Client) (Discovery, error) {
+	addr, err := newAddress(c.Advertise, c.AdvertiseName)
+	if err != nil {
+		return nil, err
+	}
+	if !c.NeedsAWS() && addr.mode != "" && addr.mode != AdvertisePrivateIP {
+		return nil, fmt.Errorf("discovery: advertise mode %s requires an aws backend", addr.mode)
+	}
+
+	switch c.Backend {
+	case "asg":
+		return &asg{aws: a, address: addr}, nil
+	case "asg-tag", "ec2-tag":
+		parts := strings.SplitN(c.Tag, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("discovery: invalid tag, expected key=value: %s", c.Tag)
+		}
+		return &tagged{
+			aws:     a,
+			address: addr,
+			ec2:     c.Backend == "ec2-tag",
+			key:     parts[0],
+			value:   parts[1],
+		}, nil
+	case "file":
+		return NewFile(c.File, c.SelfID, c.SelfHost)
+	case "srv":
+		return NewSRV(c.SRVService, c.SRVDomain, c.SelfID, c.SelfHost), nil
+	}
+	return nil, fmt.Errorf("discovery: unknown backend: %s", c.Backend)
+}
+
+// asg discovers the instances of the autoscaling group this instance belongs
+// to.
+type asg struct {
+	aws     aws.Client
+	address address
+}
+
+func (d *asg) InstanceID() string { return d.aws.InstanceID() }
+func (d *asg) Host() string       { return d.address.self(d.aws, d.Name) }
+func (d *asg) Name() string       { return d.aws.GroupName() }
+
+func (d *asg) Instances() (map[string]Instance, error) {
+	instances, err := d.aws.GroupInstances()
+	return d.address.instances(d.Name(), instances, err)
+}
+
+// tagged discovers instances by tag, either from every autoscaling group with
+// the tag or from the EC2 instances that carry it. This allows a cluster to
+// span several groups, such as one group per availability zone.
+type tagged struct {
+	aws     aws.Client
+	address address
+	ec2     bool
+	key     string
+	value   string
+}
+
+func (d *tagged) InstanceID() string { return d.aws.InstanceID() }
+func (d *tagged) Host() string       { return d.address.self(d.aws, d.Name) }
+func (d *tagged) Name() string       { return d.value }
+
+func (d *tagged) Instances() (map[string]Instance, error) {
+	var (
+		instances map[string]aws.Instance
+		err       error
+	)
+	if d.ec2 {
+		instances, err = d.aws.TaggedInstances(d.key, d.value)
+	} else {
+		instances, err = d.aws.TaggedGroupInstances(d.key, d.value)
+	}
+	return d.address.instances(d.Name(), instances, err)
+}
@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/aws"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+type MockAWS struct {
+	aws.Client
+	mock.Mock
+}
+
+func (m *MockAWS) IP() string         { return m.Called().String(0) }
+func (m *MockAWS) Hostname() string   { return m.Called().String(0) }
+func (m *MockAWS) InstanceID() string { return m.Called().String(0) }
+func (m *MockAWS) GroupName() string  { return m.Called().String(0) }
+
+func (m *MockAWS) GroupInstances() (map[string]aws.Instance, error) {
+	a := m.Called()
+	return a.Get(0).(map[string]aws.Instance), a.Error(1)
+}
+
+func (m *MockAWS) TaggedGroupInstances(key, value string) (map[string]aws.Instance, error) {
+	a := m.Called(key, value)
+	return a.Get(0).(map[string]aws.Instance), a.Error(1)
+}
+
+func (m *MockAWS) TaggedInstances(key, value string) (map[string]aws.Instance, error) {
+	a := m.Called(key, value)
+	return a.Get(0).(map[string]aws.Instance), a.Error(1)
+}
+
+func TestASG(t *testing.T) {
+	a := &MockAWS{}
+	a.On("InstanceID").Return("1")
+	a.On("IP").Return("10.0.0.1")
+	a.On("GroupName").Return("test")
+	a.On("GroupInstances").Return(map[string]aws.Instance{
+		"1": {IP: "10.0.0.1", LifecycleState: "InService", HealthStatus: "Healthy"},
+		"2": {IP: "10.0.0.2", LifecycleState: "Pending", HealthStatus: "Unhealthy"},
+	}, nil)
+
+	d, err := New(Config{Backend: "asg"}, a)
+	require.Nil(t, err)
+	require.Equal(t, "1", d.InstanceID())
+	require.Equal(t, "10.0.0.1", d.Host())
+	require.Equal(t, "test", d.Name())
+
+	instances, err := d.Instances()
+	require.Nil(t, err)
+	require.Equal(t, map[string]Instance{
+		"1": {Host: "10.0.0.1", IP: "10.0.0.1", State: "InService", Healthy: true},
+		"2": {Host: "10.0.0.2", IP: "10.0.0.2", State: "Pending", Healthy: false},
+	}, instances)
+
+	a.AssertExpectations(t)
+}
+
+func TestTagged(t *testing.T) {
+	a := &MockAWS{}
+	a.On("InstanceID").Return("1")
+	a.On("IP").Return("10.0.0.1")
+	a.On("TaggedGroupInstances", "etcd-cluster", "prod").Return(map[string]aws.Instance{
+		"1": {IP: "10.0.0.1", LifecycleState: "InService"},
+		"2": {IP: "10.0.1.1", LifecycleState: "InService"},
+	}, nil)
+	a.On("TaggedInstances", "etcd-cluster", "prod").Return(map[string]aws.Instance{
+		"1": {IP: "10.0.0.1", LifecycleState: "InService"},
+	}, nil)
+
+	d, err := New(Config{Backend: "asg-tag", Tag: "etcd-cluster=prod"}, a)
+	require.Nil(t, err)
+	require.Equal(t, "1", d.InstanceID())
+	require.Equal(t, "10.0.0.1", d.Host())
+	require.Equal(t, "prod", d.Name())
+
+	instances, err := d.Instances()
+	require.Nil(t, err)
+	require.Len(t, instances, 2)
+
+	d, err = New(Config{Backend: "ec2-tag", Tag: "etcd-cluster=prod"}, a)
+	require.Nil(t, err)
+
+	instances, err = d.Instances()
+	require.Nil(t, err)
+	require.Len(t, instances, 1)
+
+	_, err = New(Config{Backend: "asg-tag", Tag: "etcd-cluster"}, a)
+	require.NotNil(t, err)
+
+	a.AssertExpectations(t)
+}
+
+func TestNew_Unknown(t *testing.T) {
+	_, err := New(Config{Backend: "consul"}, nil)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,112 @@
+package discovery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+type peerFile struct {
+	Name      string            `yaml:"name"`
+	Instances map[string]string `yaml:"instances"`
+}
+
+// NewFile discovers instances from a static YAML or JSON file of the form:
+//
+//	name: dev
+//	instances:
+//	  etcd-1: 10.0.0.1
+//	  etcd-2: 10.0.0.2
+//
+// The file is re-read whenever it changes. The host of this instance is taken
+// from its entry in the file unless selfHost is set.
+func NewFile(path, selfID, selfHost string) (Discovery, error) {
+	d := &file{path: path, selfID: selfID, selfHost: selfHost}
+	peers, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := peers.Instances[selfID]; !ok && selfHost == "" {
+		return nil, fmt.Errorf("discovery: instance %s not found in %s", selfID, path)
+	}
+	return d, nil
+}
+
+type file struct {
+	path     string
+	selfID   string
+	selfHost string
+
+	lock    sync.Mutex
+	peers   *peerFile
+	modTime time.Time
+	size    int64
+}
+
+func (d *file) InstanceID() string { return d.selfID }
+
+func (d *file) Host() string {
+	if d.selfHost != "" {
+		return d.selfHost
+	}
+	peers, err := d.load()
+	if err != nil {
+		return ""
+	}
+	return peers.Instances[d.selfID]
+}
+
+func (d *file) Name() string {
+	peers, err := d.load()
+	if err != nil {
+		return ""
+	}
+	return peers.Name
+}
+
+func (d *file) Instances() (map[string]Instance, error) {
+	peers, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]Instance{}
+	for id, host := range peers.Instances {
+		inst := Instance{Host: host, State: StateInService, Healthy: true}
+		if net.ParseIP(host) != nil {
+			inst.IP = host
+		}
+		out[id] = inst
+	}
+	return out, nil
+}
+
+func (d *file) load() (*peerFile, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return nil, err
+	}
+	if d.peers != nil && info.ModTime().Equal(d.modTime) && info.Size() == d.size {
+		return d.peers, nil
+	}
+	data, err := ioutil.ReadFile(d.path)
+	if err != nil {
+		return nil, err
+	}
+	peers := &peerFile{}
+	err = yaml.Unmarshal(data, peers)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: invalid file %s: %v", d.path, err)
+	}
+	d.peers = peers
+	d.modTime = info.ModTime()
+	d.size = info.Size()
+	return peers, nil
+}
@@ -0,0 +1,54 @@
+package discovery
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	for _, name := range []string{"testdata/peers.yaml", "testdata/peers.json"} {
+		d, err := NewFile(name, "etcd-1", "")
+		require.Nil(t, err, name)
+		require.Equal(t, "etcd-1", d.InstanceID())
+		require.Equal(t, "10.0.0.1", d.Host())
+		require.Equal(t, "dev", d.Name())
+
+		instances, err := d.Instances()
+		require.Nil(t, err)
+		require.Equal(t, map[string]Instance{
+			"etcd-1": {Host: "10.0.0.1", IP: "10.0.0.1", State: StateInService, Healthy: true},
+			"etcd-2": {Host: "10.0.0.2", IP: "10.0.0.2", State: StateInService, Healthy: true},
+		}, instances)
+	}
+}
+
+func TestFile_SelfMissing(t *testing.T) {
+	_, err := NewFile("testdata/peers.yaml", "etcd-3", "")
+	require.NotNil(t, err)
+
+	d, err := NewFile("testdata/peers.yaml", "etcd-3", "10.0.0.3")
+	require.Nil(t, err)
+	require.Equal(t, "10.0.0.3", d.Host())
+}
+
+func TestFile_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "peers.yaml")
+	require.Nil(t, ioutil.WriteFile(name, []byte("instances: {etcd-1: 10.0.0.1}"), 0600))
+
+	d, err := NewFile(name, "etcd-1", "")
+	require.Nil(t, err)
+
+	require.Nil(t, ioutil.WriteFile(name, []byte("instances: {etcd-1: 10.0.0.1, etcd-2: 10.0.0.2}"), 0600))
+
+	instances, err := d.Instances()
+	require.Nil(t, err)
+	require.Len(t, instances, 2)
+}
@@ -0,0 +1,56 @@
+package discovery
+
+// Autoscaling lifecycle states used by the default policy. Backends that have
+// no lifecycle report every instance as InService.
+const (
+	StateInService = "InService"
+	StateStandby   = "Standby"
+)
+
+// Policy decides what an instance's lifecycle state means for the cluster.
+// Seed instances may form the initial cluster of a new cluster, kept
+// instances retain their membership and every other instance, such as one
+// that is terminating or detaching, is treated as departing.
+type Policy struct {
+	SeedStates []string
+	KeepStates []string
+}
+
+var DefaultPolicy = Policy{
+	SeedStates: []string{StateInService},
+	KeepStates: []string{
+		StateInService,
+		StateStandby,
+		"EnteringStandby",
+		"Pending",
+		"Pending:Wait",
+		"Pending:Proceed",
+	},
+}
+
+// Seed reports whether a healthy instance may seed a new cluster.
+func (p Policy) Seed(i Instance) bool {
+	states := p.SeedStates
+	if len(states) == 0 {
+		states = DefaultPolicy.SeedStates
+	}
+	return i.Healthy && contains(states, i.State)
+}
+
+// Keep reports whether a member on the instance keeps its membership.
+func (p Policy) Keep(i Instance) bool {
+	states := p.KeepStates
+	if len(states) == 0 {
+		states = DefaultPolicy.KeepStates
+	}
+	return contains(states, i.State)
+}
+
+func contains(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
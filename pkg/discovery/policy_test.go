@@ -0,0 +1,32 @@
+package discovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy_Default(t *testing.T) {
+	p := Policy{}
+
+	require.True(t, p.Seed(Instance{State: "InService", Healthy: true}))
+	require.False(t, p.Seed(Instance{State: "InService", Healthy: false}))
+	require.False(t, p.Seed(Instance{State: "Pending", Healthy: true}))
+	require.False(t, p.Seed(Instance{State: "Standby", Healthy: true}))
+
+	require.True(t, p.Keep(Instance{State: "InService"}))
+	require.True(t, p.Keep(Instance{State: "Standby"}))
+	require.True(t, p.Keep(Instance{State: "Pending:Wait"}))
+	require.False(t, p.Keep(Instance{State: "Terminating:Wait"}))
+	require.False(t, p.Keep(Instance{State: "Detaching"}))
+}
+
+func TestPolicy_Custom(t *testing.T) {
+	p := Config{
+		SeedStates: []string{"InService", "Standby"},
+		KeepStates: []string{"InService"},
+	}.Policy()
+
+	require.True(t, p.Seed(Instance{State: "Standby", Healthy: true}))
+	require.False(t, p.Keep(Instance{State: "Standby"}))
+}
@@ -0,0 +1,51 @@
+package discovery
+
+import (
+	"net"
+	"strings"
+)
+
+var lookupSRV = net.LookupSRV
+
+// NewSRV discovers instances from the targets of the _<service>._tcp.<domain>
+// SRV record. The first label of each target is used as its instance ID.
+// Ports in the records are ignored in favour of the configured ports.
+func NewSRV(service, domain, selfID, selfHost string) Discovery {
+	if selfHost == "" {
+		selfHost = selfID
+	}
+	return &srv{
+		service:  service,
+		domain:   domain,
+		selfID:   hostID(selfID),
+		selfHost: selfHost,
+	}
+}
+
+type srv struct {
+	service  string
+	domain   string
+	selfID   string
+	selfHost string
+}
+
+func (d *srv) InstanceID() string { return d.selfID }
+func (d *srv) Host() string       { return d.selfHost }
+func (d *srv) Name() string       { return d.domain }
+
+func (d *srv) Instances() (map[string]Instance, error) {
+	_, addrs, err := lookupSRV(d.service, "tcp", d.domain)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]Instance{}
+	for _, addr := range addrs {
+		host := strings.TrimSuffix(addr.Target, ".")
+		out[hostID(host)] = Instance{Host: host, State: StateInService, Healthy: true}
+	}
+	return out, nil
+}
+
+func hostID(host string) string {
+	return strings.SplitN(host, ".", 2)[0]
+}
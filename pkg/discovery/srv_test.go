@@ -0,0 +1,33 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSRV(t *testing.T) {
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		require.Equal(t, "etcd-server-ssl", service)
+		require.Equal(t, "tcp", proto)
+		require.Equal(t, "etcd.internal", name)
+		return "", []*net.SRV{
+			{Target: "etcd-1.etcd.internal.", Port: 2380},
+			{Target: "etcd-2.etcd.internal.", Port: 2380},
+		}, nil
+	}
+	defer func() { lookupSRV = net.LookupSRV }()
+
+	d := NewSRV("etcd-server-ssl", "etcd.internal", "etcd-1.etcd.internal", "")
+	require.Equal(t, "etcd-1", d.InstanceID())
+	require.Equal(t, "etcd-1.etcd.internal", d.Host())
+	require.Equal(t, "etcd.internal", d.Name())
+
+	instances, err := d.Instances()
+	require.Nil(t, err)
+	require.Equal(t, map[string]Instance{
+		"etcd-1": {Host: "etcd-1.etcd.internal", State: StateInService, Healthy: true},
+		"etcd-2": {Host: "etcd-2.etcd.internal", State: StateInService, Healthy: true},
+	}, instances)
+}
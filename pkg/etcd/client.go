@@ -2,12 +2,16 @@ package etcd
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	etcd "github.com/coreos/etcd/client"
@@ -15,11 +19,15 @@ import (
 
 type connectFunc = func(url string) (etcd.MembersAPI, error)
 
-func connector(tp etcd.CancelableTransport) connectFunc {
+func connector(tp func() (etcd.CancelableTransport, error)) connectFunc {
 	return func(url string) (etcd.MembersAPI, error) {
+		t, err := tp()
+		if err != nil {
+			return nil, err
+		}
 		cl, err := etcd.New(etcd.Config{
 			Endpoints: []string{url},
-			Transport: tp,
+			Transport: t,
 		})
 		if err != nil {
 			return nil, err
@@ -34,10 +42,44 @@ type Client interface {
 	Remove(clientHostname, candidateHostname string) error
 	IsAvailable(hostname string) bool
 	Members(hostname string) (map[string]string, error)
+
+	// HasPeer reports whether a member with the peer url of the candidate is
+	// in the cluster, including members that were added but not started.
+	HasPeer(clientHostname, candidateHostname string) (bool, error)
+
+	// Healthy reports whether the member at hostname is serving requests,
+	// which an available member that is still catching up is not.
+	Healthy(hostname string) bool
+
+	// MoveLeader transfers leadership to another started member when the
+	// named member is the leader, so that removing it does not stall the
+	// cluster on an election.
+	MoveLeader(clientHostname, name string) error
 }
 
+// ErrMoveLeaderUnsupported is returned by MoveLeader for clusters that have
+// no leader transfer api, which was added in etcd 3.3.
+var ErrMoveLeaderUnsupported = errors.New("etcd: leader transfer is not supported by this cluster")
+
+// gatewayPrefixes are the grpc gateway prefixes of the v3 api, newest first.
+var gatewayPrefixes = []string{"/v3", "/v3beta", "/v3alpha"}
+
 type Config struct {
+	// Outputs are extra files the realized config is written to, each of the
+	// form <format>:<path> with one of the env, yaml, json, flags or template
+	// formats.
+	Outputs []string
+
+	// TemplateFile is a text/template executed for the template output.
+	TemplateFile string
+
+	// StaticPodImage is the etcd image of the static-pod output.
+	StaticPodImage string
+
 	EnvFile        string
+	EnvFileMode    string
+	EnvFileUID     string
+	EnvFileGID     string
 	ClientScheme   string
 	ClientCertFile string
 	ClientCAFile   string
@@ -48,6 +90,128 @@ type Config struct {
 	PeerCAFile     string
 	PeerKeyFile    string
 	PeerPort       string
+
+	// ClientServerName overrides the name used to verify member
+	// certificates, which is needed when dialing members by IP address and
+	// their certificates only carry DNS names.
+	ClientServerName    string
+	ClientTLSMinVersion string
+	ClientCipherSuites  []string
+	ClientExtraCAFiles  []string
+
+	// Secret references of the form "ssm:<name>" or "secretsmanager:<id>".
+	// When set, the matching file is written from the secret before the
+	// config is rendered.
+	ClientCASecret   string
+	ClientCertSecret string
+	ClientKeySecret  string
+	PeerCASecret     string
+	PeerCertSecret   string
+	PeerKeySecret    string
+
+	// When CABucket is set, client and peer certificates for this instance
+	// are issued locally from a CA stored in S3. The CA key object must be
+	// encrypted with KMS. Validity and renewal windows are durations.
+	CABucket        string
+	CACertKey       string
+	CAKeyKey        string
+	CertValidity    string
+	CertRenewBefore string
+
+	// Credentials used when etcd auth is enabled. They are only rendered to
+	// the secret env file, which is written with tighter permissions.
+	Username          string
+	Password          string `json:"-"`
+	SecretEnvFile     string
+	SecretEnvFileMode string
+
+	// When Route53ZoneID is set, A records for members and SRV records for
+	// the cluster are kept in the zone under Route53Domain. The srv bootstrap
+	// renders ETCD_DISCOVERY_SRV instead of an explicit initial cluster.
+	Route53ZoneID string
+	Route53Domain string
+	Route53TTL    string
+	Bootstrap     string
+
+	// When TargetGroupARN is set, members are registered with the target
+	// group once they serve requests and deregistered, waiting up to the
+	// drain timeout, before they are removed.
+	TargetGroupARN          string
+	TargetGroupDrainTimeout string
+
+	// StartupTimeout bounds how long startup waits for the instance metadata,
+	// the autoscaling group, the InService state and the certificate files.
+	StartupTimeout string
+
+	// In watch mode the spot and scheduled maintenance notices are polled
+	// every NoticeInterval. On a spot interruption, or a maintenance event
+	// within MaintenanceLeaveBefore, the member leaves the cluster.
+	NoticeInterval         string
+	MaintenanceLeaveBefore string
+
+	// ListenAddress is the address etcd listens on, either an IP or
+	// "private-ip" for the private IP of the instance. ListenLocalhost adds
+	// the loopback address to the client urls when listening on a single
+	// address. AdvertiseClientHosts are advertised in addition to the
+	// instance host, "private-ip", "private-dns" and "record" select the
+	// addresses of the instance. With a MetricsPort the metrics are served
+	// over plain http on that port.
+	ListenAddress        string
+	ListenLocalhost      string
+	AdvertiseClientHosts []string
+	MetricsPort          string
+
+	// Artifacts for consumers of the cluster, written on every run. The
+	// etcdctl env file and the Prometheus file_sd list are local files, the
+	// endpoints manifest is also uploaded to S3 when a bucket is set.
+	ArtifactFileMode string
+	EtcdctlEnvFile   string
+	PrometheusSDFile string
+	EndpointsFile    string
+	EndpointsBucket  string
+	EndpointsKey     string
+
+	// MaxVoters caps the number of voting members. Instances beyond the cap
+	// run etcd as a proxy of the members and are promoted when a voter goes
+	// away. Empty or zero means every instance votes. PromoteCommand is run
+	// through the shell to restart etcd once a promoted instance has its
+	// member config written.
+	MaxVoters      string
+	PromoteCommand string
+
+	// Tuning is passed through to every output. TuningDefaultsFile holds
+	// defaults keyed by the EC2 instance type for the values left unset.
+	Tuning             Tuning
+	TuningDefaultsFile string
+}
+
+// CertificateFile is a CA, cert or key file of a https scheme. Managed files
+// are written by this tool from a secret or issued from the CA bucket, the
+// others are provisioned by something else.
+type CertificateFile struct {
+	Setting string
+	Path    string
+	Managed bool
+}
+
+// CertificateFiles returns the certificate files of every https scheme.
+func (c Config) CertificateFiles() (files []CertificateFile) {
+	issued := c.CABucket != ""
+	if c.ClientScheme == "https" {
+		files = append(files,
+			CertificateFile{"ETCD_CLIENT_CA_FILE", c.ClientCAFile, issued || c.ClientCASecret != ""},
+			CertificateFile{"ETCD_CLIENT_CERT_FILE", c.ClientCertFile, issued || c.ClientCertSecret != ""},
+			CertificateFile{"ETCD_CLIENT_KEY_FILE", c.ClientKeyFile, issued || c.ClientKeySecret != ""},
+		)
+	}
+	if c.PeerScheme == "https" {
+		files = append(files,
+			CertificateFile{"ETCD_PEER_CA_FILE", c.PeerCAFile, issued || c.PeerCASecret != ""},
+			CertificateFile{"ETCD_PEER_CERT_FILE", c.PeerCertFile, issued || c.PeerCertSecret != ""},
+			CertificateFile{"ETCD_PEER_KEY_FILE", c.PeerKeyFile, issued || c.PeerKeySecret != ""},
+		)
+	}
+	return files
 }
 
 func (c Config) PeerURL(hostname string) string {
@@ -67,23 +231,26 @@ func (c Config) PeerURLs(m map[string]string) (urls []string) {
 }
 
 func NewClient(c Config) (Client, error) {
-	tp := http.DefaultTransport.(*http.Transport)
+	tp := func() (etcd.CancelableTransport, error) {
+		return http.DefaultTransport.(*http.Transport), nil
+	}
+	// The tls files are loaded on first use, so that files written from
+	// secrets or issued by the first run can be created after the client.
 	if c.ClientScheme == "https" {
-		var err error
-		tp, err = transport(c.ClientCertFile, c.ClientKeyFile, c.ClientCAFile)
-		if err != nil {
-			return nil, err
-		}
+		cache := &transportCache{config: c}
+		tp = func() (etcd.CancelableTransport, error) { return cache.get() }
 	}
 	return &client{
-		config:  c,
-		connect: connector(tp),
+		config:    c,
+		connect:   connector(tp),
+		transport: tp,
 	}, nil
 }
 
 type client struct {
-	config  Config
-	connect connectFunc
+	config    Config
+	connect   connectFunc
+	transport func() (etcd.CancelableTransport, error)
 }
 
 func (c *client) Config() Config { return c.config }
@@ -179,22 +346,122 @@ func (c *client) Members(hostname string) (map[string]string, error) {
 	return membs, nil
 }
 
-func transport(certFile, keyFile, caFile string) (*http.Transport, error) {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+func (c *client) HasPeer(clientHostname, candidateHostname string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	api, err := c.connect(c.config.ClientURL(clientHostname))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	caCert, err := ioutil.ReadFile(caFile)
+	l, err := api.List(ctx)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{cert},
-		RootCAs:      caCertPool,
+	candidateURL := c.config.PeerURL(candidateHostname)
+	for _, m := range l {
+		for _, u := range m.PeerURLs {
+			if u == candidateURL {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (c *client) Healthy(hostname string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tp, err := c.transport()
+	if err != nil {
+		return false
+	}
+	req, err := http.NewRequest("GET", c.config.ClientURL(hostname)+"/health", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := (&http.Client{Transport: tp}).Do(req.WithContext(ctx))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Health string `json:"health"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&health) != nil {
+		return false
+	}
+	return health.Health == "true"
+}
+
+func (c *client) MoveLeader(clientHostname, name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	api, err := c.connect(c.config.ClientURL(clientHostname))
+	if err != nil {
+		return err
+	}
+	leader, err := api.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	if leader == nil || leader.Name != name {
+		return nil
+	}
+	if len(leader.ClientURLs) == 0 {
+		return fmt.Errorf("etcd: leader has no client urls: %s", name)
+	}
+	membs, err := api.List(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(membs, func(i, j int) bool { return membs[i].Name < membs[j].Name })
+
+	for _, m := range membs {
+		// Members that have not started yet have no name.
+		if m.Name == "" || m.Name == name {
+			continue
+		}
+		id, err := strconv.ParseUint(m.ID, 16, 64)
+		if err != nil {
+			return fmt.Errorf("etcd: invalid member id %s: %v", m.ID, err)
+		}
+		return c.transferLeadership(ctx, leader.ClientURLs[0], id)
+	}
+	return errors.New("etcd: no member to move leadership to")
+}
+
+// transferLeadership asks the leader at leaderURL to hand over leadership,
+// through the grpc gateway as the v2 api has no equivalent.
+func (c *client) transferLeadership(ctx context.Context, leaderURL string, id uint64) error {
+	tp, err := c.transport()
+	if err != nil {
+		return err
+	}
+	body := fmt.Sprintf(`{"targetID":"%d"}`, id)
+	for _, prefix := range gatewayPrefixes {
+		req, err := http.NewRequest("POST", leaderURL+prefix+"/maintenance/transfer-leadership", strings.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := (&http.Client{Transport: tp}).Do(req.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			continue
+		case resp.StatusCode != http.StatusOK:
+			return fmt.Errorf("etcd: leader transfer failed: %s", resp.Status)
+		}
+		return nil
 	}
-	tlsConfig.BuildNameToCertificate()
-	transport := &http.Transport{TLSClientConfig: tlsConfig}
-	return transport, nil
+	return ErrMoveLeaderUnsupported
 }
@@ -2,6 +2,10 @@ package etcd
 
 import (
 	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"testing"
 
@@ -29,6 +33,11 @@ func (m *MockAPI) Remove(ctx context.Context, id string) error {
 	return a.Error(0)
 }
 
+func (m *MockAPI) Leader(ctx context.Context) (*etcd.Member, error) {
+	a := m.Called()
+	return a.Get(0).(*etcd.Member), a.Error(1)
+}
+
 func (m *MockAPI) List(ctx context.Context) ([]etcd.Member, error) {
 	a := m.Called()
 	return a.Get(0).([]etcd.Member), a.Error(1)
@@ -43,11 +52,11 @@ var etcdTestConfig = Config{
 
 func TestTransport(t *testing.T) {
 	dir, _ := os.Getwd()
-	_, err := transport(
-		dir+"/testdata/etcd.pem",
-		dir+"/testdata/etcd-key.pem",
-		dir+"/testdata/etcd-ca.pem",
-	)
+	_, err := transport(Config{
+		ClientCertFile: dir + "/testdata/etcd.pem",
+		ClientKeyFile:  dir + "/testdata/etcd-key.pem",
+		ClientCAFile:   dir + "/testdata/etcd-ca.pem",
+	})
 	require.Nil(t, err)
 }
 
@@ -151,3 +160,99 @@ func TestClient_Members(t *testing.T) {
 
 	m.AssertExpectations(t)
 }
+
+func TestClient_HasPeer(t *testing.T) {
+	m := &MockAPI{}
+
+	// A member that was added but has not started has no name or client urls.
+	m.On("List").Return([]etcd.Member{
+		{ID: "xxxxxx", Name: "1", ClientURLs: []string{"https://1.ec2.internal:2380"}, PeerURLs: []string{"https://1.ec2.internal:2379"}},
+		{ID: "yyyyyy", PeerURLs: []string{"https://3.ec2.internal:2379"}},
+	}, nil)
+
+	c := &client{
+		config:  etcdTestConfig,
+		connect: m.connect,
+	}
+
+	added, err := c.HasPeer("1.ec2.internal", "3.ec2.internal")
+	require.Nil(t, err)
+	require.True(t, added)
+
+	added, err = c.HasPeer("1.ec2.internal", "2.ec2.internal")
+	require.Nil(t, err)
+	require.False(t, added)
+}
+
+func TestClient_Healthy(t *testing.T) {
+	health := `{"health": "true"}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/health", r.URL.Path)
+		w.Write([]byte(health))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	c, err := NewClient(Config{ClientScheme: "http", ClientPort: u.Port()})
+	require.Nil(t, err)
+	require.True(t, c.Healthy(u.Hostname()))
+
+	health = `{"health": "false"}`
+	require.False(t, c.Healthy(u.Hostname()))
+
+	server.Close()
+	require.False(t, c.Healthy(u.Hostname()))
+}
+
+func TestClient_MoveLeader(t *testing.T) {
+	var paths, bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/v3beta/maintenance/transfer-leadership" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	m := &MockAPI{}
+	m.On("Leader").Return(&etcd.Member{ID: "a", Name: "1", ClientURLs: []string{server.URL}}, nil)
+	m.On("List").Return([]etcd.Member{
+		{ID: "a", Name: "1"},
+		{ID: "c", Name: "3"},
+		{ID: "b"},
+		{ID: "2b", Name: "2"},
+	}, nil)
+
+	c, err := NewClient(Config{ClientScheme: "http"})
+	require.Nil(t, err)
+	c.(*client).connect = m.connect
+
+	// Not the leader, nothing to move.
+	require.Nil(t, c.MoveLeader("2.ec2.internal", "2"))
+	require.Empty(t, paths)
+
+	require.Nil(t, c.MoveLeader("2.ec2.internal", "1"))
+	require.Equal(t, []string{
+		"/v3/maintenance/transfer-leadership",
+		"/v3beta/maintenance/transfer-leadership",
+	}, paths)
+	require.Equal(t, []string{`{"targetID":"43"}`}, bodies)
+}
+
+func TestClient_MoveLeaderUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	m := &MockAPI{}
+	m.On("Leader").Return(&etcd.Member{ID: "a", Name: "1", ClientURLs: []string{server.URL}}, nil)
+	m.On("List").Return([]etcd.Member{{ID: "a", Name: "1"}, {ID: "b", Name: "2"}}, nil)
+
+	c, err := NewClient(Config{ClientScheme: "http"})
+	require.Nil(t, err)
+	c.(*client).connect = m.connect
+	require.Equal(t, ErrMoveLeaderUnsupported, c.MoveLeader("2.ec2.internal", "1"))
+}
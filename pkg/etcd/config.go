@@ -1,20 +1,35 @@
 package etcd
 
 import (
-	"os"
+	"strings"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/settings"
 )
 
+// env resolves a setting from the flags, the environment or the config file.
 func env(name, defaults string) string {
-	val := os.Getenv(name)
-	if val == "" {
-		return defaults
+	return settings.Get(name, defaults)
+}
+
+func envList(name, defaults string) (out []string) {
+	for _, item := range strings.Split(env(name, defaults), ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			out = append(out, item)
+		}
 	}
-	return val
+	return
 }
 
 func GetEnvConfig() Config {
 	return Config{
+		Outputs:        envList("ETCD_OUTPUTS", ""),
+		TemplateFile:   env("ETCD_TEMPLATE_FILE", ""),
+		StaticPodImage: env("ETCD_STATIC_POD_IMAGE", "quay.io/coreos/etcd:v3.3.27"),
 		EnvFile:        env("ETCD_ENV_FILE", "/etc/etcd/config"),
+		EnvFileMode:    env("ETCD_ENV_FILE_MODE", "0700"),
+		EnvFileUID:     env("ETCD_ENV_FILE_UID", ""),
+		EnvFileGID:     env("ETCD_ENV_FILE_GID", ""),
 		ClientScheme:   env("ETCD_CLIENT_SCHEME", "https"),
 		ClientPort:     env("ETCD_CLIENT_PORT", "2379"),
 		ClientCAFile:   env("ETCD_CLIENT_CA_FILE", "/etc/etcd/certs/ca.pem"),
@@ -25,5 +40,67 @@ func GetEnvConfig() Config {
 		PeerCAFile:     env("ETCD_PEER_CA_FILE", "/etc/etcd/certs/peer-ca.pem"),
 		PeerCertFile:   env("ETCD_PEER_CERT_FILE", "/etc/etcd/certs/peer-etcd.pem"),
 		PeerKeyFile:    env("ETCD_PEER_KEY_FILE", "/etc/etcd/certs/peer-etcd-key.pem"),
+
+		ClientServerName:    env("ETCD_CLIENT_SERVER_NAME", ""),
+		ClientTLSMinVersion: env("ETCD_CLIENT_TLS_MIN_VERSION", "1.2"),
+		ClientCipherSuites:  envList("ETCD_CLIENT_CIPHER_SUITES", ""),
+		ClientExtraCAFiles:  envList("ETCD_CLIENT_EXTRA_CA_FILES", ""),
+
+		ClientCASecret:   env("ETCD_CLIENT_CA_SECRET", ""),
+		ClientCertSecret: env("ETCD_CLIENT_CERT_SECRET", ""),
+		ClientKeySecret:  env("ETCD_CLIENT_KEY_SECRET", ""),
+		PeerCASecret:     env("ETCD_PEER_CA_SECRET", ""),
+		PeerCertSecret:   env("ETCD_PEER_CERT_SECRET", ""),
+		PeerKeySecret:    env("ETCD_PEER_KEY_SECRET", ""),
+
+		CABucket:        env("ETCD_CA_BUCKET", ""),
+		CACertKey:       env("ETCD_CA_CERT_KEY", "ca.pem"),
+		CAKeyKey:        env("ETCD_CA_KEY_KEY", "ca-key.pem.encrypted"),
+		CertValidity:    env("ETCD_CERT_VALIDITY", "8760h"),
+		CertRenewBefore: env("ETCD_CERT_RENEW_BEFORE", "720h"),
+
+		Username:          env("ETCD_USERNAME", ""),
+		Password:          env("ETCD_PASSWORD", ""),
+		SecretEnvFile:     env("ETCD_SECRET_ENV_FILE", ""),
+		SecretEnvFileMode: env("ETCD_SECRET_ENV_FILE_MODE", "0600"),
+
+		Route53ZoneID: env("ETCD_ROUTE53_ZONE_ID", ""),
+		Route53Domain: env("ETCD_ROUTE53_DOMAIN", ""),
+		Route53TTL:    env("ETCD_ROUTE53_TTL", "60"),
+		Bootstrap:     env("ETCD_BOOTSTRAP", "static"),
+
+		TargetGroupARN:          env("ETCD_TARGET_GROUP_ARN", ""),
+		TargetGroupDrainTimeout: env("ETCD_TARGET_GROUP_DRAIN_TIMEOUT", "5m"),
+
+		StartupTimeout: env("ETCD_STARTUP_TIMEOUT", "10m"),
+
+		NoticeInterval:         env("ETCD_NOTICE_INTERVAL", "5s"),
+		MaintenanceLeaveBefore: env("ETCD_MAINTENANCE_LEAVE_BEFORE", "15m"),
+
+		ListenAddress:        env("ETCD_LISTEN_ADDRESS", "0.0.0.0"),
+		ListenLocalhost:      env("ETCD_LISTEN_LOCALHOST", "false"),
+		AdvertiseClientHosts: envList("ETCD_ADVERTISE_CLIENT_HOSTS", ""),
+		MetricsPort:          env("ETCD_METRICS_PORT", ""),
+
+		ArtifactFileMode: env("ETCD_ARTIFACT_FILE_MODE", "0644"),
+		EtcdctlEnvFile:   env("ETCD_ETCDCTL_ENV_FILE", ""),
+		PrometheusSDFile: env("ETCD_PROMETHEUS_SD_FILE", ""),
+		EndpointsFile:    env("ETCD_ENDPOINTS_FILE", ""),
+		EndpointsBucket:  env("ETCD_ENDPOINTS_BUCKET", ""),
+		EndpointsKey:     env("ETCD_ENDPOINTS_KEY", "endpoints.json"),
+
+		MaxVoters:      env("ETCD_MAX_VOTERS", ""),
+		PromoteCommand: env("ETCD_PROMOTE_COMMAND", ""),
+
+		Tuning: Tuning{
+			DataDir:                 env("ETCD_DATA_DIR", ""),
+			HeartbeatInterval:       env("ETCD_HEARTBEAT_INTERVAL", ""),
+			ElectionTimeout:         env("ETCD_ELECTION_TIMEOUT", ""),
+			QuotaBackendBytes:       env("ETCD_QUOTA_BACKEND_BYTES", ""),
+			SnapshotCount:           env("ETCD_SNAPSHOT_COUNT", ""),
+			AutoCompactionMode:      env("ETCD_AUTO_COMPACTION_MODE", ""),
+			AutoCompactionRetention: env("ETCD_AUTO_COMPACTION_RETENTION", ""),
+		},
+		TuningDefaultsFile: env("ETCD_TUNING_DEFAULTS_FILE", ""),
 	}
 }
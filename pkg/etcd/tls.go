@@ -0,0 +1,157 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+}
+
+var cipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+func tlsConfig(c Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.ClientCertFile, c.ClientKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	caCertPool := x509.NewCertPool()
+	for _, caFile := range append([]string{c.ClientCAFile}, c.ClientExtraCAFiles...) {
+		err = appendCAFile(caCertPool, caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	minVersion := uint16(tls.VersionTLS12)
+	if c.ClientTLSMinVersion != "" {
+		v, ok := tlsVersions[c.ClientTLSMinVersion]
+		if !ok {
+			return nil, fmt.Errorf("etcd: unsupported tls version: %s", c.ClientTLSMinVersion)
+		}
+		minVersion = v
+	}
+
+	var suites []uint16
+	for _, name := range c.ClientCipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			return nil, fmt.Errorf("etcd: unsupported cipher suite: %s", name)
+		}
+		suites = append(suites, id)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      caCertPool,
+		ServerName:   c.ClientServerName,
+		MinVersion:   minVersion,
+		CipherSuites: suites,
+	}
+	tlsConfig.BuildNameToCertificate()
+	return tlsConfig, nil
+}
+
+// appendCAFile adds every certificate in a PEM bundle to the pool. Unlike
+// AppendCertsFromPEM it fails on a bundle that holds no usable certificates
+// or that contains a block which does not parse.
+func appendCAFile(pool *x509.CertPool, caFile string) error {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("etcd: invalid certificate in ca file %s: %v", caFile, err)
+		}
+		pool.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return fmt.Errorf("etcd: no certificates found in ca file: %s", caFile)
+	}
+	return nil
+}
+
+func transport(c Config) (*http.Transport, error) {
+	cfg, err := tlsConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Transport{TLSClientConfig: cfg}, nil
+}
+
+// transportCache rebuilds the transport when any of the certificate files
+// change on disk so that refreshed certificates are used without a restart.
+type transportCache struct {
+	config Config
+
+	lock  sync.Mutex
+	tp    *http.Transport
+	stamp string
+}
+
+func (t *transportCache) get() (*http.Transport, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	stamp := t.fileStamp()
+	if t.tp != nil && stamp == t.stamp {
+		return t.tp, nil
+	}
+	tp, err := transport(t.config)
+	if err != nil {
+		return nil, err
+	}
+	if t.tp != nil {
+		t.tp.CloseIdleConnections()
+	}
+	t.tp = tp
+	t.stamp = stamp
+	return tp, nil
+}
+
+func (t *transportCache) fileStamp() (stamp string) {
+	files := []string{t.config.ClientCertFile, t.config.ClientKeyFile, t.config.ClientCAFile}
+	for _, name := range append(files, t.config.ClientExtraCAFiles...) {
+		if info, err := os.Stat(name); err == nil {
+			stamp += fmt.Sprintf("%s:%d:%d;", name, info.ModTime().UnixNano(), info.Size())
+		}
+	}
+	return
+}
@@ -0,0 +1,12 @@
+//go:build go1.12
+// +build go1.12
+
+package etcd
+
+import "crypto/tls"
+
+// TLS 1.3 is only known to the standard library from Go 1.12, builds with an
+// older Go reject it as an unsupported version.
+func init() {
+	tlsVersions["1.3"] = tls.VersionTLS13
+}
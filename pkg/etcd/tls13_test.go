@@ -0,0 +1,24 @@
+//go:build go1.12
+// +build go1.12
+
+package etcd
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSConfig_TLS13(t *testing.T) {
+	c := tlsTestConfig
+	c.ClientTLSMinVersion = "1.3"
+
+	cfg, err := tlsConfig(c)
+	require.Nil(t, err)
+	require.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+
+	v := validConfig()
+	v.ClientTLSMinVersion = "1.3"
+	require.NoError(t, v.Validate())
+}
@@ -0,0 +1,83 @@
+package etcd
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var tlsTestConfig = Config{
+	ClientCertFile: "testdata/etcd.pem",
+	ClientKeyFile:  "testdata/etcd-key.pem",
+	ClientCAFile:   "testdata/etcd-ca.pem",
+}
+
+func TestTLSConfig_Defaults(t *testing.T) {
+	cfg, err := tlsConfig(tlsTestConfig)
+	require.Nil(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), cfg.MinVersion)
+	require.Empty(t, cfg.ServerName)
+	require.Nil(t, cfg.CipherSuites)
+	require.Len(t, cfg.RootCAs.Subjects(), 1)
+}
+
+func TestTLSConfig_Options(t *testing.T) {
+	c := tlsTestConfig
+	c.ClientServerName = "etcd.internal"
+	c.ClientTLSMinVersion = "1.1"
+	c.ClientCipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}
+	c.ClientExtraCAFiles = []string{"testdata/other-ca.pem"}
+
+	cfg, err := tlsConfig(c)
+	require.Nil(t, err)
+	require.Equal(t, "etcd.internal", cfg.ServerName)
+	require.Equal(t, uint16(tls.VersionTLS11), cfg.MinVersion)
+	require.Equal(t, []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}, cfg.CipherSuites)
+	require.Len(t, cfg.RootCAs.Subjects(), 2)
+}
+
+func TestTLSConfig_Invalid(t *testing.T) {
+	for name, mod := range map[string]func(c *Config){
+		"missing ca":      func(c *Config) { c.ClientCAFile = "testdata/missing.pem" },
+		"empty ca":        func(c *Config) { c.ClientCAFile = "testdata/etcd-key.pem" },
+		"invalid ca":      func(c *Config) { c.ClientExtraCAFiles = []string{"testdata/invalid-ca.pem"} },
+		"tls version":     func(c *Config) { c.ClientTLSMinVersion = "0.9" },
+		"cipher suite":    func(c *Config) { c.ClientCipherSuites = []string{"TLS_NOPE"} },
+		"missing keypair": func(c *Config) { c.ClientKeyFile = "" },
+	} {
+		c := tlsTestConfig
+		mod(&c)
+		_, err := tlsConfig(c)
+		require.NotNil(t, err, name)
+	}
+}
+
+func TestTransportCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	c := tlsTestConfig
+	c.ClientCAFile = filepath.Join(dir, "ca.pem")
+	ca, _ := ioutil.ReadFile("testdata/etcd-ca.pem")
+	require.Nil(t, ioutil.WriteFile(c.ClientCAFile, ca, 0600))
+
+	cache := &transportCache{config: c}
+	first, err := cache.get()
+	require.Nil(t, err)
+	second, err := cache.get()
+	require.Nil(t, err)
+	require.True(t, first == second)
+
+	other, _ := ioutil.ReadFile("testdata/other-ca.pem")
+	require.Nil(t, ioutil.WriteFile(c.ClientCAFile, append(ca, other...), 0600))
+
+	third, err := cache.get()
+	require.Nil(t, err)
+	require.False(t, first == third)
+	require.Len(t, third.TLSClientConfig.RootCAs.Subjects(), 2)
+}
@@ -0,0 +1,127 @@
+package etcd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// etcd defaults for the timing settings, used to validate a partial tuning.
+const (
+	defaultHeartbeatInterval = 100
+	defaultElectionTimeout   = 1000
+)
+
+// Tuning are etcd settings passed through to every output. Unset values are
+// left out so etcd uses its own defaults. Intervals are in milliseconds.
+type Tuning struct {
+	DataDir                 string `yaml:"data-dir"`
+	HeartbeatInterval       string `yaml:"heartbeat-interval"`
+	ElectionTimeout         string `yaml:"election-timeout"`
+	QuotaBackendBytes       string `yaml:"quota-backend-bytes"`
+	SnapshotCount           string `yaml:"snapshot-count"`
+	AutoCompactionMode      string `yaml:"auto-compaction-mode"`
+	AutoCompactionRetention string `yaml:"auto-compaction-retention"`
+}
+
+// Validate checks the values etcd would otherwise reject at startup, and
+// that the election timeout is at least five heartbeats as etcd recommends.
+func (t Tuning) Validate() error {
+	heartbeat, err := tuningInt("heartbeat interval", t.HeartbeatInterval, defaultHeartbeatInterval)
+	if err != nil {
+		return err
+	}
+	election, err := tuningInt("election timeout", t.ElectionTimeout, defaultElectionTimeout)
+	if err != nil {
+		return err
+	}
+	if election < 5*heartbeat {
+		return fmt.Errorf("etcd: election timeout (%dms) must be at least 5x the heartbeat interval (%dms)", election, heartbeat)
+	}
+	if _, err = tuningInt("quota backend bytes", t.QuotaBackendBytes, 0); err != nil {
+		return err
+	}
+	if _, err = tuningInt("snapshot count", t.SnapshotCount, 0); err != nil {
+		return err
+	}
+	switch t.AutoCompactionMode {
+	case "", "periodic", "revision":
+	default:
+		return fmt.Errorf("etcd: auto compaction mode must be periodic or revision: %s", t.AutoCompactionMode)
+	}
+	return nil
+}
+
+func tuningInt(name, value string, defaults int64) (int64, error) {
+	if value == "" {
+		return defaults, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("etcd: %s must be a positive number: %s", name, value)
+	}
+	return n, nil
+}
+
+// WithDefaults fills the unset values from the defaults.
+func (t Tuning) WithDefaults(d Tuning) Tuning {
+	fill := func(v *string, d string) {
+		if *v == "" {
+			*v = d
+		}
+	}
+	fill(&t.DataDir, d.DataDir)
+	fill(&t.HeartbeatInterval, d.HeartbeatInterval)
+	fill(&t.ElectionTimeout, d.ElectionTimeout)
+	fill(&t.QuotaBackendBytes, d.QuotaBackendBytes)
+	fill(&t.SnapshotCount, d.SnapshotCount)
+	fill(&t.AutoCompactionMode, d.AutoCompactionMode)
+	fill(&t.AutoCompactionRetention, d.AutoCompactionRetention)
+	return t
+}
+
+// InstanceTypeTuning reads the tuning defaults for an instance type from a
+// YAML file keyed by instance type. Keys may be globs such as "t3.*", an
+// exact match wins over globs and longer globs over shorter ones.
+func InstanceTypeTuning(file, instanceType string) (Tuning, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Tuning{}, err
+	}
+	defaults := map[string]Tuning{}
+	err = yaml.UnmarshalStrict(data, &defaults)
+	if err != nil {
+		return Tuning{}, fmt.Errorf("etcd: invalid tuning defaults %s: %v", file, err)
+	}
+	if t, ok := defaults[instanceType]; ok {
+		return t, nil
+	}
+
+	var patterns []string
+	for pattern := range defaults {
+		if strings.ContainsAny(pattern, "*?[") {
+			patterns = append(patterns, pattern)
+		}
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+	for _, pattern := range patterns {
+		ok, err := path.Match(pattern, instanceType)
+		if err != nil {
+			return Tuning{}, fmt.Errorf("etcd: invalid instance type pattern %s: %v", pattern, err)
+		}
+		if ok {
+			return defaults[pattern], nil
+		}
+	}
+	return Tuning{}, nil
+}
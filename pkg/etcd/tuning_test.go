@@ -0,0 +1,71 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTuning_Validate(t *testing.T) {
+	require.Nil(t, Tuning{}.Validate())
+	require.Nil(t, Tuning{
+		HeartbeatInterval:  "200",
+		ElectionTimeout:    "1000",
+		QuotaBackendBytes:  "8589934592",
+		SnapshotCount:      "10000",
+		AutoCompactionMode: "periodic",
+	}.Validate())
+
+	for _, tuning := range []Tuning{
+		{HeartbeatInterval: "300"},
+		{HeartbeatInterval: "100", ElectionTimeout: "499"},
+		{ElectionTimeout: "1s"},
+		{QuotaBackendBytes: "8GB"},
+		{SnapshotCount: "-1"},
+		{AutoCompactionMode: "hourly"},
+	} {
+		require.NotNil(t, tuning.Validate(), "%+v", tuning)
+	}
+}
+
+func TestTuning_WithDefaults(t *testing.T) {
+	tuning := Tuning{HeartbeatInterval: "100"}.WithDefaults(Tuning{
+		HeartbeatInterval: "250",
+		ElectionTimeout:   "2500",
+	})
+	require.Equal(t, Tuning{HeartbeatInterval: "100", ElectionTimeout: "2500"}, tuning)
+}
+
+func TestInstanceTypeTuning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tuning")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "tuning.yaml")
+	require.Nil(t, ioutil.WriteFile(file, []byte(`
+"t3.*":
+  heartbeat-interval: 250
+  election-timeout: 2500
+"t3.nano":
+  quota-backend-bytes: 1073741824
+"*":
+  snapshot-count: 10000
+`), 0644))
+
+	for instanceType, expected := range map[string]Tuning{
+		"t3.nano":  {QuotaBackendBytes: "1073741824"},
+		"t3.large": {HeartbeatInterval: "250", ElectionTimeout: "2500"},
+		"m5.large": {SnapshotCount: "10000"},
+	} {
+		tuning, err := InstanceTypeTuning(file, instanceType)
+		require.Nil(t, err)
+		require.Equal(t, expected, tuning, instanceType)
+	}
+
+	require.Nil(t, ioutil.WriteFile(file, []byte("t3.large:\n  heartbeat: 250\n"), 0644))
+	_, err = InstanceTypeTuning(file, "t3.large")
+	require.NotNil(t, err)
+}
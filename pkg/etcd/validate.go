@@ -0,0 +1,213 @@
+package etcd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every problem found in a config.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return "etcd: invalid config:\n  " + strings.Join(e, "\n  ")
+}
+
+// Validate checks the config up front so that a typo fails at startup with
+// every problem listed, instead of as a connect failure or a broken etcd
+// later on. Certificate files only need to be set, they may be written by
+// this tool or another process later and startup waits for them.
+func (c Config) Validate() error {
+	var errs ValidationError
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Sprintf(format, args...))
+	}
+
+	for _, s := range []struct{ name, value string }{
+		{"ETCD_CLIENT_SCHEME", c.ClientScheme},
+		{"ETCD_PEER_SCHEME", c.PeerScheme},
+	} {
+		if s.value != "http" && s.value != "https" {
+			add("%s must be http or https: %q", s.name, s.value)
+		}
+	}
+
+	clientPort, clientOK := validPort(c.ClientPort)
+	if !clientOK {
+		add("ETCD_CLIENT_PORT must be a port between 1 and 65535: %q", c.ClientPort)
+	}
+	peerPort, peerOK := validPort(c.PeerPort)
+	if !peerOK {
+		add("ETCD_PEER_PORT must be a port between 1 and 65535: %q", c.PeerPort)
+	}
+	if clientOK && peerOK && clientPort == peerPort {
+		add("ETCD_CLIENT_PORT and ETCD_PEER_PORT must differ: %d", clientPort)
+	}
+	if c.MetricsPort != "" {
+		metricsPort, ok := validPort(c.MetricsPort)
+		switch {
+		case !ok:
+			add("ETCD_METRICS_PORT must be a port between 1 and 65535: %q", c.MetricsPort)
+		case (clientOK && metricsPort == clientPort) || (peerOK && metricsPort == peerPort):
+			add("ETCD_METRICS_PORT must differ from the client and peer ports: %d", metricsPort)
+		}
+	}
+
+	if c.ListenAddress != "" && c.ListenAddress != "private-ip" && net.ParseIP(c.ListenAddress) == nil {
+		add("ETCD_LISTEN_ADDRESS must be an IP or private-ip: %q", c.ListenAddress)
+	}
+	if c.ListenLocalhost != "" {
+		if _, err := strconv.ParseBool(c.ListenLocalhost); err != nil {
+			add("ETCD_LISTEN_LOCALHOST must be true or false: %q", c.ListenLocalhost)
+		}
+	}
+
+	// Files written from a secret or issued from the CA bucket only exist
+	// after the first run, the others must be readable up front.
+	for _, f := range c.CertificateFiles() {
+		if f.Path == "" {
+			add("%s must be set with https", f.Setting)
+			continue
+		}
+		if f.Managed {
+			continue
+		}
+		if err := readableFile(f.Path); err != nil {
+			add("%s: %v", f.Setting, err)
+		}
+	}
+	if c.ClientScheme == "https" {
+		for _, name := range c.ClientExtraCAFiles {
+			if err := readableFile(name); err != nil {
+				add("ETCD_CLIENT_EXTRA_CA_FILES: %v", err)
+			}
+		}
+	}
+	if c.ClientTLSMinVersion != "" {
+		if _, ok := tlsVersions[c.ClientTLSMinVersion]; !ok {
+			add("ETCD_CLIENT_TLS_MIN_VERSION is not supported: %q", c.ClientTLSMinVersion)
+		}
+	}
+	for _, name := range c.ClientCipherSuites {
+		if _, ok := cipherSuites[name]; !ok {
+			add("ETCD_CLIENT_CIPHER_SUITES has an unsupported cipher suite: %q", name)
+		}
+	}
+
+	for _, f := range []struct{ name, value string }{
+		{"ETCD_TEMPLATE_FILE", c.TemplateFile},
+		{"ETCD_TUNING_DEFAULTS_FILE", c.TuningDefaultsFile},
+	} {
+		if f.value == "" {
+			continue
+		}
+		if err := readableFile(f.value); err != nil {
+			add("%s: %v", f.name, err)
+		}
+	}
+
+	for _, m := range []struct{ name, value string }{
+		{"ETCD_ENV_FILE_MODE", c.EnvFileMode},
+		{"ETCD_SECRET_ENV_FILE_MODE", c.SecretEnvFileMode},
+		{"ETCD_ARTIFACT_FILE_MODE", c.ArtifactFileMode},
+	} {
+		if m.value == "" {
+			continue
+		}
+		if _, err := strconv.ParseUint(m.value, 8, 32); err != nil {
+			add("%s must be an octal mode: %q", m.name, m.value)
+		}
+	}
+	for _, id := range []struct{ name, value string }{
+		{"ETCD_ENV_FILE_UID", c.EnvFileUID},
+		{"ETCD_ENV_FILE_GID", c.EnvFileGID},
+	} {
+		if id.value == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(id.value); err != nil {
+			add("%s must be numeric: %q", id.name, id.value)
+		}
+	}
+
+	for _, d := range []struct{ name, value string }{
+		{"ETCD_CERT_VALIDITY", c.CertValidity},
+		{"ETCD_CERT_RENEW_BEFORE", c.CertRenewBefore},
+		{"ETCD_TARGET_GROUP_DRAIN_TIMEOUT", c.TargetGroupDrainTimeout},
+		{"ETCD_STARTUP_TIMEOUT", c.StartupTimeout},
+		{"ETCD_NOTICE_INTERVAL", c.NoticeInterval},
+		{"ETCD_MAINTENANCE_LEAVE_BEFORE", c.MaintenanceLeaveBefore},
+	} {
+		if d.value == "" || d.value == "0" {
+			continue
+		}
+		if _, err := time.ParseDuration(d.value); err != nil {
+			add("%s must be a duration: %q", d.name, d.value)
+		}
+	}
+
+	if c.Route53TTL != "" {
+		if ttl, err := strconv.ParseInt(c.Route53TTL, 10, 64); err != nil || ttl <= 0 {
+			add("ETCD_ROUTE53_TTL must be a positive number: %q", c.Route53TTL)
+		}
+	}
+	if c.Route53ZoneID != "" && strings.TrimSuffix(c.Route53Domain, ".") == "" {
+		add("ETCD_ROUTE53_ZONE_ID needs ETCD_ROUTE53_DOMAIN for the record names")
+	}
+	switch c.Bootstrap {
+	case "", "static":
+	case "srv":
+		if c.Route53ZoneID == "" {
+			add("ETCD_BOOTSTRAP=srv needs ETCD_ROUTE53_ZONE_ID")
+		}
+	default:
+		add("ETCD_BOOTSTRAP must be static or srv: %q", c.Bootstrap)
+	}
+
+	if c.EndpointsBucket != "" && c.EndpointsFile == "" {
+		add("ETCD_ENDPOINTS_BUCKET needs ETCD_ENDPOINTS_FILE for the uploaded manifest")
+	}
+
+	if c.MaxVoters != "" && c.MaxVoters != "0" {
+		if n, err := strconv.Atoi(c.MaxVoters); err != nil || n < 0 {
+			add("ETCD_MAX_VOTERS must be a positive number: %q", c.MaxVoters)
+		} else if c.Tuning.DataDir == "" {
+			add("ETCD_MAX_VOTERS needs ETCD_DATA_DIR to keep the proxy data apart from the member data")
+		}
+	}
+
+	if err := c.Tuning.Validate(); err != nil {
+		add("%s", strings.TrimPrefix(err.Error(), "etcd: "))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validPort(value string) (int, bool) {
+	port, err := strconv.Atoi(value)
+	return port, err == nil && port > 0 && port <= 65535
+}
+
+// readableFile checks that a file exists, is not a directory and can be
+// opened for reading.
+func readableFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", name)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package etcd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func validConfig() Config {
+	return Config{
+		ClientScheme:   "https",
+		ClientPort:     "2379",
+		ClientCAFile:   "testdata/etcd-ca.pem",
+		ClientCertFile: "testdata/etcd.pem",
+		ClientKeyFile:  "testdata/etcd-key.pem",
+		PeerScheme:     "https",
+		PeerPort:       "2380",
+		PeerCAFile:     "testdata/etcd-ca.pem",
+		PeerCertFile:   "testdata/etcd.pem",
+		PeerKeyFile:    "testdata/etcd-key.pem",
+		EnvFileMode:    "0700",
+		Route53TTL:     "60",
+		Bootstrap:      "static",
+		StartupTimeout: "10m",
+		NoticeInterval: "5s",
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	require.NoError(t, validConfig().Validate())
+}
+
+func TestConfig_ValidateHTTP(t *testing.T) {
+	c := validConfig()
+	c.ClientScheme = "http"
+	c.PeerScheme = "http"
+	c.ClientCertFile = "missing.pem"
+	c.PeerCertFile = ""
+	require.NoError(t, c.Validate())
+}
+
+func TestConfig_ValidateAggregates(t *testing.T) {
+	c := validConfig()
+	c.ClientScheme = "htps"
+	c.PeerPort = "abc"
+	c.PeerKeyFile = ""
+	c.EnvFileMode = "0900"
+	c.StartupTimeout = "10"
+	c.Bootstrap = "dns"
+	c.Tuning.HeartbeatInterval = "500"
+
+	err := c.Validate()
+	require.Error(t, err)
+	errs, ok := err.(ValidationError)
+	require.True(t, ok)
+	require.Equal(t, ValidationError{
+		`ETCD_CLIENT_SCHEME must be http or https: "htps"`,
+		`ETCD_PEER_PORT must be a port between 1 and 65535: "abc"`,
+		"ETCD_PEER_KEY_FILE must be set with https",
+		`ETCD_ENV_FILE_MODE must be an octal mode: "0900"`,
+		`ETCD_STARTUP_TIMEOUT must be a duration: "10"`,
+		`ETCD_BOOTSTRAP must be static or srv: "dns"`,
+		"election timeout (1000ms) must be at least 5x the heartbeat interval (500ms)",
+	}, errs)
+	require.Contains(t, err.Error(), "etcd: invalid config:\n  ETCD_CLIENT_SCHEME")
+}
+
+func TestConfig_ValidatePorts(t *testing.T) {
+	c := validConfig()
+	c.PeerPort = c.ClientPort
+	require.Equal(t, ValidationError{"ETCD_CLIENT_PORT and ETCD_PEER_PORT must differ: 2379"}, c.Validate())
+
+	c.PeerPort = "70000"
+	require.Equal(t, ValidationError{`ETCD_PEER_PORT must be a port between 1 and 65535: "70000"`}, c.Validate())
+}
+
+func TestConfig_ValidateCertificateFiles(t *testing.T) {
+	c := validConfig()
+	c.ClientCertFile = "testdata/missing.pem"
+	c.PeerCAFile = "testdata/missing-ca.pem"
+	require.Equal(t, ValidationError{
+		"ETCD_CLIENT_CERT_FILE: open testdata/missing.pem: no such file or directory",
+		"ETCD_PEER_CA_FILE: open testdata/missing-ca.pem: no such file or directory",
+	}, c.Validate())
+
+	// Files written from secrets or issued from the CA bucket do not exist
+	// before the first run.
+	c.ClientCertSecret = "ssm:/etcd/cert.pem"
+	require.Equal(t, ValidationError{
+		"ETCD_PEER_CA_FILE: open testdata/missing-ca.pem: no such file or directory",
+	}, c.Validate())
+	c.CABucket = "ca-bucket"
+	require.NoError(t, c.Validate())
+
+	c.ClientKeyFile = ""
+	require.Equal(t, ValidationError{"ETCD_CLIENT_KEY_FILE must be set with https"}, c.Validate())
+}
+
+func TestConfig_ValidateUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := validConfig()
+	c.TuningDefaultsFile = dir
+	c.TemplateFile = dir + "/missing.tmpl"
+	err = c.Validate()
+	require.Equal(t, ValidationError{
+		"ETCD_TEMPLATE_FILE: open " + dir + "/missing.tmpl: no such file or directory",
+		"ETCD_TUNING_DEFAULTS_FILE: " + dir + " is a directory",
+	}, err)
+}
+
+func TestConfig_ValidateSRV(t *testing.T) {
+	c := validConfig()
+	c.Bootstrap = "srv"
+	require.Equal(t, ValidationError{"ETCD_BOOTSTRAP=srv needs ETCD_ROUTE53_ZONE_ID"}, c.Validate())
+	c.Route53ZoneID = "Z123"
+	c.Route53Domain = "etcd.internal"
+	require.NoError(t, c.Validate())
+}
+
+func TestConfig_ValidateRoute53(t *testing.T) {
+	c := validConfig()
+	c.Route53ZoneID = "Z123"
+	require.Equal(t, ValidationError{"ETCD_ROUTE53_ZONE_ID needs ETCD_ROUTE53_DOMAIN for the record names"}, c.Validate())
+
+	c.Route53Domain = "etcd.internal."
+	require.NoError(t, c.Validate())
+}
+
+func TestConfig_ValidateMaxVoters(t *testing.T) {
+	c := validConfig()
+	c.MaxVoters = "five"
+	require.Equal(t, ValidationError{`ETCD_MAX_VOTERS must be a positive number: "five"`}, c.Validate())
+
+	c.MaxVoters = "5"
+	require.Equal(t, ValidationError{"ETCD_MAX_VOTERS needs ETCD_DATA_DIR to keep the proxy data apart from the member data"}, c.Validate())
+
+	c.Tuning.DataDir = "/var/lib/etcd"
+	require.NoError(t, c.Validate())
+}
+
+func TestConfig_ValidateListen(t *testing.T) {
+	c := validConfig()
+	c.ListenAddress = "private-ip"
+	c.ListenLocalhost = "true"
+	c.MetricsPort = "2381"
+	require.NoError(t, c.Validate())
+
+	c.ListenAddress = "eth0"
+	c.ListenLocalhost = "yes"
+	c.MetricsPort = "2379"
+	require.Equal(t, ValidationError{
+		"ETCD_METRICS_PORT must differ from the client and peer ports: 2379",
+		`ETCD_LISTEN_ADDRESS must be an IP or private-ip: "eth0"`,
+		`ETCD_LISTEN_LOCALHOST must be true or false: "yes"`,
+	}, c.Validate())
+}
+
+func TestConfig_ValidateEndpoints(t *testing.T) {
+	c := validConfig()
+	c.EndpointsBucket = "bucket"
+	require.Equal(t, ValidationError{"ETCD_ENDPOINTS_BUCKET needs ETCD_ENDPOINTS_FILE for the uploaded manifest"}, c.Validate())
+	c.EndpointsFile = "/etc/etcd/endpoints.json"
+	require.NoError(t, c.Validate())
+}
@@ -0,0 +1,174 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+type CA struct {
+	Cert *x509.Certificate
+	Key  crypto.Signer
+}
+
+// ParseCA loads a PEM encoded CA certificate and private key. The key may be
+// PKCS1, PKCS8 or SEC1 encoded.
+func ParseCA(certPEM, keyPEM []byte) (*CA, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, errors.New("pki: ca certificate is not pem encoded")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if !cert.IsCA {
+		return nil, errors.New("pki: certificate is not a ca")
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("pki: ca key is not pem encoded")
+	}
+	key, err := parseKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key}, nil
+}
+
+func parseKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errors.New("pki: unsupported ca key format")
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, errors.New("pki: unsupported ca key type")
+}
+
+type Request struct {
+	CommonName string
+	Hosts      []string
+	Validity   time.Duration
+}
+
+// Issue signs a new certificate usable for both server and client auth, which
+// is what etcd expects for its client and peer certificates. Hosts are added
+// as IP or DNS SANs depending on their form.
+func (ca *CA) Issue(req Request) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: req.CommonName},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(req.Validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage: []x509.ExtKeyUsage{
+			x509.ExtKeyUsageServerAuth,
+			x509.ExtKeyUsageClientAuth,
+		},
+	}
+	for _, host := range req.Hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else if host != "" {
+			tpl.DNSNames = append(tpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.Cert, key.Public(), ca.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// NeedsRenewal reports whether the certificate is missing, unparsable, within
+// renewBefore of expiry or no longer covers every host. It also reports true
+// when the key does not belong to the certificate or the certificate is not
+// signed by the CA, which catches a pair left half written or a rotated CA.
+func NeedsRenewal(certPEM, keyPEM, caPEM []byte, hosts []string, renewBefore time.Duration) bool {
+	cert, err := parseCert(certPEM)
+	if err != nil {
+		return true
+	}
+	if time.Now().Add(renewBefore).After(cert.NotAfter) {
+		return true
+	}
+	for _, host := range hosts {
+		if host != "" && cert.VerifyHostname(host) != nil {
+			return true
+		}
+	}
+	if !matchesKey(cert, keyPEM) {
+		return true
+	}
+	ca, err := parseCert(caPEM)
+	if err != nil {
+		return true
+	}
+	return cert.CheckSignatureFrom(ca) != nil
+}
+
+func parseCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("pki: certificate is not pem encoded")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func matchesKey(cert *x509.Certificate, keyPEM []byte) bool {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return false
+	}
+	key, err := parseKey(block.Bytes)
+	if err != nil {
+		return false
+	}
+	public, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return false
+	}
+	certPublic, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(public, certPublic)
+}
@@ -0,0 +1,79 @@
+package pki
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/coldog/etcd-aws-cluster/pkg/pki/pkitest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCA_Issue(t *testing.T) {
+	caPEM, caKeyPEM, err := pkitest.CA()
+	require.Nil(t, err)
+	ca, err := ParseCA(caPEM, caKeyPEM)
+	require.Nil(t, err)
+
+	certPEM, keyPEM, err := ca.Issue(Request{
+		CommonName: "i-1",
+		Hosts:      []string{"10.0.0.1", "ip-10-0-0-1.ec2.internal"},
+		Validity:   time.Hour,
+	})
+	require.Nil(t, err)
+	require.NotEmpty(t, keyPEM)
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	require.Nil(t, err)
+	require.Equal(t, "i-1", cert.Subject.CommonName)
+	require.Equal(t, []string{"ip-10-0-0-1.ec2.internal"}, cert.DNSNames)
+	require.Equal(t, "10.0.0.1", cert.IPAddresses[0].String())
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		DNSName:   "10.0.0.1",
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	require.Nil(t, err)
+}
+
+func TestParseCA_Invalid(t *testing.T) {
+	caPEM, caKeyPEM, err := pkitest.CA()
+	require.Nil(t, err)
+
+	_, err = ParseCA([]byte("nope"), caKeyPEM)
+	require.NotNil(t, err)
+	_, err = ParseCA(caPEM, []byte("nope"))
+	require.NotNil(t, err)
+}
+
+func TestNeedsRenewal(t *testing.T) {
+	caPEM, caKeyPEM, err := pkitest.CA()
+	require.Nil(t, err)
+	ca, err := ParseCA(caPEM, caKeyPEM)
+	require.Nil(t, err)
+
+	req := Request{
+		CommonName: "i-1",
+		Hosts:      []string{"10.0.0.1"},
+		Validity:   2 * time.Hour,
+	}
+	certPEM, keyPEM, err := ca.Issue(req)
+	require.Nil(t, err)
+	_, otherKeyPEM, err := ca.Issue(req)
+	require.Nil(t, err)
+	otherCAPEM, _, err := pkitest.CA()
+	require.Nil(t, err)
+
+	require.False(t, NeedsRenewal(certPEM, keyPEM, caPEM, []string{"10.0.0.1"}, time.Hour))
+	require.True(t, NeedsRenewal(certPEM, keyPEM, caPEM, []string{"10.0.0.1"}, 3*time.Hour))
+	require.True(t, NeedsRenewal(certPEM, keyPEM, caPEM, []string{"10.0.0.2"}, time.Hour))
+	require.True(t, NeedsRenewal(nil, nil, caPEM, nil, time.Hour))
+	require.True(t, NeedsRenewal(certPEM, otherKeyPEM, caPEM, []string{"10.0.0.1"}, time.Hour))
+	require.True(t, NeedsRenewal(certPEM, nil, caPEM, []string{"10.0.0.1"}, time.Hour))
+	require.True(t, NeedsRenewal(certPEM, keyPEM, otherCAPEM, []string{"10.0.0.1"}, time.Hour))
+}
@@ -0,0 +1,165 @@
+// Package settings resolves configuration values from command line flags,
+// environment variables and a YAML config file, in that order of precedence,
+// and records where each value came from.
+package settings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Sources of a setting.
+const (
+	SourceFlag    = "flag"
+	SourceEnv     = "env"
+	SourceFile    = "file"
+	SourceDefault = "default"
+)
+
+// Setting is a resolved value and where it came from.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+var (
+	lock  sync.Mutex
+	file  = map[string]string{}
+	flags = map[string]string{}
+	read  = map[string]Setting{}
+	order []string
+)
+
+// Get returns the named setting from a flag, the environment, the config file
+// or the default. Empty values count as unset.
+func Get(name, defaults string) string {
+	lock.Lock()
+	defer lock.Unlock()
+
+	s := Setting{Name: name, Value: defaults, Source: SourceDefault}
+	if val, ok := flags[name]; ok {
+		s.Value, s.Source = val, SourceFlag
+	} else if val := os.Getenv(name); val != "" {
+		s.Value, s.Source = val, SourceEnv
+	} else if val := file[name]; val != "" {
+		s.Value, s.Source = val, SourceFile
+	}
+
+	if _, ok := read[name]; !ok {
+		order = append(order, name)
+	}
+	read[name] = s
+	return s.Value
+}
+
+// SetFlag sets a setting from a command line flag.
+func SetFlag(name, value string) {
+	lock.Lock()
+	defer lock.Unlock()
+	flags[name] = value
+}
+
+// LoadFile reads a YAML config file of settings. Keys are either the
+// environment variable names or their lower case form without the ETCD_
+// prefix, so `client-scheme` sets ETCD_CLIENT_SCHEME. Lists are joined with
+// commas.
+func LoadFile(name string) error {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	values := map[string]fileValue{}
+	err = yaml.Unmarshal(data, &values)
+	if err != nil {
+		return fmt.Errorf("settings: invalid config file %s: %v", name, err)
+	}
+
+	loaded := map[string]string{}
+	for key, value := range values {
+		if value.nested {
+			return fmt.Errorf("settings: %s in %s must be a value or a list", key, name)
+		}
+		loaded[envName(key)] = value.text
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	file = loaded
+	return nil
+}
+
+// fileValue is a value of the config file. Scalars keep their text as
+// written, as resolving them would turn a file mode such as 0644 into the
+// number 420. Booleans are the exception so that yes and on read as true.
+type fileValue struct {
+	text   string
+	nested bool
+}
+
+func (v *fileValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var resolved interface{}
+	err := unmarshal(&resolved)
+	if err != nil {
+		return err
+	}
+	switch r := resolved.(type) {
+	case nil:
+		return nil
+	case bool:
+		v.text = strconv.FormatBool(r)
+		return nil
+	case []interface{}:
+		var items []string
+		err = unmarshal(&items)
+		v.text = strings.Join(items, ",")
+		return err
+	case map[interface{}]interface{}:
+		v.nested = true
+		return nil
+	}
+	return unmarshal(&v.text)
+}
+
+// envName maps a config file key to its environment variable name.
+func envName(key string) string {
+	if strings.HasPrefix(key, "ETCD_") {
+		return key
+	}
+	return "ETCD_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(key))
+}
+
+// Unknown returns the config file settings that were never read, which are
+// most likely typos.
+func Unknown() []string {
+	lock.Lock()
+	defer lock.Unlock()
+
+	var out []string
+	for name := range file {
+		if _, ok := read[name]; !ok {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// List returns the settings read so far, in the order they were first read.
+func List() []Setting {
+	lock.Lock()
+	defer lock.Unlock()
+
+	out := make([]Setting, len(order))
+	for i, name := range order {
+		out[i] = read[name]
+	}
+	return out
+}
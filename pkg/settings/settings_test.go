@@ -0,0 +1,112 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func reset() {
+	file = map[string]string{}
+	flags = map[string]string{}
+	read = map[string]Setting{}
+	order = nil
+}
+
+func writeConfig(t *testing.T, data string) string {
+	f, err := ioutil.TempFile("", "settings")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(data)
+	require.NoError(t, err)
+	return f.Name()
+}
+
+func TestSettings_Precedence(t *testing.T) {
+	reset()
+	defer reset()
+
+	name := writeConfig(t, "client-port: 3000\npeer-port: 3001\nETCD_PEER_SCHEME: http\nname: file\n")
+	defer os.Remove(name)
+	require.NoError(t, LoadFile(name))
+
+	os.Setenv("ETCD_PEER_PORT", "4001")
+	os.Setenv("ETCD_NAME", "env")
+	defer os.Unsetenv("ETCD_PEER_PORT")
+	defer os.Unsetenv("ETCD_NAME")
+	SetFlag("ETCD_NAME", "flag")
+
+	require.Equal(t, "3000", Get("ETCD_CLIENT_PORT", "2379"))
+	require.Equal(t, "4001", Get("ETCD_PEER_PORT", "2380"))
+	require.Equal(t, "http", Get("ETCD_PEER_SCHEME", "https"))
+	require.Equal(t, "flag", Get("ETCD_NAME", ""))
+	require.Equal(t, "default", Get("ETCD_OTHER", "default"))
+	require.Equal(t, "3000", Get("ETCD_CLIENT_PORT", "2379"))
+
+	require.Equal(t, []Setting{
+		{Name: "ETCD_CLIENT_PORT", Value: "3000", Source: SourceFile},
+		{Name: "ETCD_PEER_PORT", Value: "4001", Source: SourceEnv},
+		{Name: "ETCD_PEER_SCHEME", Value: "http", Source: SourceFile},
+		{Name: "ETCD_NAME", Value: "flag", Source: SourceFlag},
+		{Name: "ETCD_OTHER", Value: "default", Source: SourceDefault},
+	}, List())
+}
+
+func TestSettings_Lists(t *testing.T) {
+	reset()
+	defer reset()
+
+	name := writeConfig(t, "outputs:\n  - yaml:/a.yaml\n  - json:/a.json\n")
+	defer os.Remove(name)
+	require.NoError(t, LoadFile(name))
+	require.Equal(t, "yaml:/a.yaml,json:/a.json", Get("ETCD_OUTPUTS", ""))
+}
+
+func TestSettings_RawValues(t *testing.T) {
+	reset()
+	defer reset()
+
+	// Unquoted modes are octal text, not the number yaml resolves them to.
+	name := writeConfig(t, "env-file-mode: 0644\nsecret-env-file-mode: 0600\nlisten-localhost: yes\nclient-extra-ca-files: [0644, b]\n")
+	defer os.Remove(name)
+	require.NoError(t, LoadFile(name))
+	require.Equal(t, "0644", Get("ETCD_ENV_FILE_MODE", "0700"))
+	require.Equal(t, "0600", Get("ETCD_SECRET_ENV_FILE_MODE", "0600"))
+	require.Equal(t, "true", Get("ETCD_LISTEN_LOCALHOST", ""))
+	require.Equal(t, "0644,b", Get("ETCD_CLIENT_EXTRA_CA_FILES", ""))
+}
+
+func TestSettings_Nested(t *testing.T) {
+	reset()
+	defer reset()
+
+	name := writeConfig(t, "tuning:\n  heartbeat-interval: 100\n")
+	defer os.Remove(name)
+	require.Error(t, LoadFile(name))
+}
+
+func TestSettings_Invalid(t *testing.T) {
+	reset()
+	defer reset()
+
+	name := writeConfig(t, "- not a map\n")
+	defer os.Remove(name)
+	require.Error(t, LoadFile(name))
+	require.Error(t, LoadFile(name+".missing"))
+}
+
+func TestSettings_Unknown(t *testing.T) {
+	reset()
+	defer reset()
+
+	name := writeConfig(t, "client-port: 3000\nclinet-scheme: http\nwatch-interval: 1m\n")
+	defer os.Remove(name)
+	require.NoError(t, LoadFile(name))
+
+	Get("ETCD_CLIENT_PORT", "2379")
+	require.Equal(t, []string{"ETCD_CLINET_SCHEME", "ETCD_WATCH_INTERVAL"}, Unknown())
+	Get("ETCD_WATCH_INTERVAL", "5m")
+	require.Equal(t, []string{"ETCD_CLINET_SCHEME"}, Unknown())
+}